@@ -2,27 +2,118 @@ package config
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	utils "github.com/ftarlao/duplito/utils"
 )
 
 type Options struct {
-	RecurseFlag        bool
-	UpdateFlag         bool
-	UpdateFullFlag     bool
-	IgnoreErrorsFlag   bool
-	NumThreads         int // New flag for number of threads
-	Warnings           bool
-	Summary            bool
-	Overall            bool
-	MinDirPerc         int
-	MinDirBytes        int64
-	OutputType         int //0 ALL, 1 SUMMARY, 2 ONLY FINAL SUMMARY
-	DuplicatesOnlyFlag bool
-	MinFileBytes       int64
+	RecurseFlag         bool
+	UpdateFlag          bool
+	UpdateFullFlag      bool
+	IgnoreErrorsFlag    bool
+	NumThreads          int // New flag for number of threads
+	Warnings            bool
+	Summary             bool
+	Overall             bool
+	MinDirPerc          int
+	MinDirBytes         int64
+	OutputType          int //0 ALL, 1 SUMMARY, 2 ONLY FINAL SUMMARY
+	DuplicatesOnlyFlag  bool
+	MinFileBytes        int64
+	Format              string             //per file line template, e.g. '{status} {size} {path}'
+	VerifyOnMatch       bool               //full hash duplicates before reporting them
+	HardlinkSavings     bool               //report the space saved by files sharing an inode
+	ParallelFolders     int                //number of folders classified concurrently when listing
+	MaxOpenFiles        int                //max files open at the same time by hashing workers, 0 unlimited
+	SizeHistogram       bool               //report duplicate bytes bucketed by file size
+	MinReclaimPerc      int                //min percentage of reclaimable bytes to display a folder
+	SparseAware         bool               //full hash reads only allocated extents, skips holes and zero blocks
+	IncludeXattrs       bool               //extended attributes are folded into the hash
+	TinyMultiplier      int64              //quick hash reads fully the files up to this many areas
+	FullHashAbove       int64              //with -u, files bigger than this are fully hashed, 0 never
+	NoAtime             bool               //hashing does not update the access time (Linux, owned files)
+	ResumeHash          bool               //on a read error the readable prefix of the file is hashed
+	ErrorLog            string             //file listing the files an update could not read
+	DryRun              bool               //with -u/-U only reports what the update would change
+	JSONAll             bool               //list every file as a JSON line, with status and duplicates
+	TrimDBTo            string             //comma separated roots, database entries outside them are removed
+	OrphanFolders       bool               //report the database folders no longer on disk
+	RemoveOrphanFolders bool               //remove from the database the files of the folders no longer on disk
+	DiffFlag            bool               //compare the two provided files, report the first differing byte
+	ResumeFlag          bool               //continue an interrupted update from its checkpoint
+	ColorScheme         string             //auto, default, colorblind or mono
+	ColorSections       string             //all, files or headers: the parts of the listing colored
+	SizeCollisions      int                //report the N filesizes shared by most files, 0 disabled
+	FilesOfSize         int64              //lists the catalogued files of this size, -1 disabled
+	MaxErrorRate        float64            //percentage of failed files that stops an update, 0 disables
+	ShowHash            bool               //append the truncated hash to the listed files
+	Aliases             utils.PathAliases  //display names of real roots, the database keeps the real paths
+	MaxFiles            int64              //files found that stop an update, 0 unlimited
+	GenScript           string             //path of the cleanup shell script to write
+	ProgressFromDB      bool               //estimate the update progress from the files in the database
+	NullTerminated      bool               //file lines end with NUL and paths are not escaped, like find -print0
+	CompareHashModes    int                //compare quick and full hash on the N biggest shared sizes, 0 disabled
+	KeepNewest          bool               //delete the older copies of each duplicate group under the paths
+	Yes                 bool               //do not ask confirmation for destructive actions
+	MinIndexBytes       int64              //files smaller than this are not added to the database by updates
+	HashSymlinks        bool               //catalog symlinks with their target path string as content
+	StreamChunk         int                //files of a folder classified and printed at a time, 0 whole folder
+	SkipMagic           string             //comma separated hex prefixes of the file contents not added to the database
+	DuplicateDirs       bool               //report the folders holding the same files
+	DirPairs            int                //report the N folder pairs sharing the most duplicate bytes, 0 disabled
+	ZeroByteDuplicates  bool               //zero size files are listed as duplicates of each other
+	Grep                string             //only lists the files whose path matches this regular expression
+	MoveFolder          string             //old=new, rewrites the paths of a moved folder in the database
+	UnindexedOnly       bool               //only lists the files missing from the database, and their folders
+	Fadvise             string             //page cache advice for the hashed files: none, sequential or dontneed
+	HideClean           bool               //hide the folders without duplicates
+	SingleThread        bool               //walk, hash and collect one file at a time, in walk order
+	MaxCatalogAge       string             //list mode warns when the last update is older, e.g. 7d or 12h
+	HTMLReport          string             //path of the HTML duplicates report to write
+	SizeTolerance       float64            //report files with sizes within this percentage and the same first bytes, 0 disabled
+	PrintDuplicatesNull bool               //print the removable copies NUL terminated, for xargs -0
+	CheckDB             bool               //verify the consistency of the database
+	FilenameEncoding    string             //encoding of the names that are not valid UTF-8: utf8 or latin1
+	CPUProfile          string             //file where the pprof CPU profile is written
+	MemProfile          string             //file where the pprof heap profile is written at exit
+	CaseCollisions      bool               //report the catalogued paths differing only by case
+	CheckStdin          bool               //report the catalogued files with the same content as stdin
+	DBStats             bool               //print the overall stats from the database alone
+	MaxRuntime          string             //update time budget, e.g. 30m, the scan stops when exceeded
+	Containing          string             //comma separated folders, reports the duplicate groups with a copy under them
+	DiffDB              bool               //compare two files databases (saved copies of filemap.gob)
+	VerifyGroupsOver    int                //full hash the duplicate groups with more copies before reporting them, 0 disabled
+	MaxHashBytes        int64              //-U reads at most the first bytes of each file, 0 unlimited
+	ReportHeader        bool               //prints the provenance of the listing before it
+	RemoveEmptyDirs     bool               //removes the folders left empty by --keep-newest
+	KeepUnder           utils.PathList     //master copies folders, never removed and preferred as kept copy
+	EstimateOnly        bool               //upper bound of the duplicates from the filesizes, nothing is hashed
+	ActionLog           string             //file recording the deletions of --keep-newest
+	ResumeAction        string             //action log of an interrupted --keep-newest to continue
+	Excludes            utils.NamePatterns //names skipped by the walks, --exclude and --exclude-os-junk
+	ExcludeOSJunk       bool
+	FlatGroups          bool   //prints the duplicate groups globally, one path per line
+	GroupSeparator      string //line after each group of --flat-groups
+	GroupMarker         bool   //header line with hash and copies before each group of --flat-groups
+	DetectTruncated     bool   //reports the files that are the beginning of a bigger file
+	Largest             int    //reports the N biggest files, 0 disabled
+	TSV                 bool   //prints hash, size and path of every file, tab separated
+	RmlintJSON          bool   //prints the duplicate groups as an rmlint json-dump
+	DedupeSimulation    bool   //reports the disk used before and after removing the duplicates
+	PrintConfig         bool   `json:"-"` //prints the resolved options as JSON and exits
+	Verify              bool   //hashes again the catalogued files and reports those changed
+	FailOnChanged       bool   //with Verify, exit status 1 when any file changed
+	LimitPerFolder      int    //file lines listed per folder, duplicates first, 0 unlimited
+	SummaryByRoot       bool   //statistics of each listed path before the overall ones
+	LogLevel            string //debug, info, warn or error
 }
 
 // loadMap
@@ -35,10 +126,18 @@ func LoadMap() (map[utils.HashPair][]string, error) {
 	}
 	configPath := filepath.Join(homeDir, ".duplito", "filemap.gob")
 
-	file, err := os.Open(configPath)
-	if os.IsNotExist(err) {
+	filemap, err := LoadMapFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
 		return map[utils.HashPair][]string{}, nil // Return empty map if file doesn't exist
-	} else if err != nil {
+	}
+	return filemap, err
+}
+
+// LoadMapFile reads a files database from configPath, e.g. a saved copy of
+// filemap.gob.
+func LoadMapFile(configPath string) (map[utils.HashPair][]string, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
 		return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(configPath), err)
 	}
 	defer file.Close()
@@ -50,7 +149,7 @@ func LoadMap() (map[utils.HashPair][]string, error) {
 	return filemap, nil
 }
 
-//saveMap saves the map to ~/.duplito/filemap.gob, creating the folder if needed.
+// saveMap saves the map to ~/.duplito/filemap.gob, creating the folder if needed.
 func SaveMap(filemap map[utils.HashPair][]string) error {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -80,10 +179,343 @@ func SaveMap(filemap map[utils.HashPair][]string) error {
 // If a path appears multiple times (unexpected), the last hash is used.
 func InvertMap(hashMap map[utils.HashPair][]string) map[string]utils.HashPair {
 	inverted := make(map[string]utils.HashPair)
+	EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		inverted[path] = hashPair
+	})
+	return inverted
+}
+
+// TrimMap removes from hashMap the paths that are not under one of the roots,
+// roots must be absolute and clean. Returns the number of removed paths.
+func TrimMap(hashMap map[utils.HashPair][]string, roots []string) int {
+	return filterMap(hashMap, func(path string) bool { return utils.IsUnderAny(path, roots) })
+}
+
+// RemoveUnder removes from hashMap the paths under one of the folders, folders
+// must be absolute and clean. Returns the number of removed paths.
+func RemoveUnder(hashMap map[utils.HashPair][]string, folders []string) int {
+	return filterMap(hashMap, func(path string) bool { return !utils.IsUnderAny(path, folders) })
+}
+
+// filterMap keeps in hashMap the paths for which keep is true, the groups left
+// empty are deleted. Returns the number of removed paths.
+func filterMap(hashMap map[utils.HashPair][]string, keep func(path string) bool) int {
+	removed := 0
 	for hashPair, paths := range hashMap {
+		kept := paths[:0]
 		for _, path := range paths {
-			inverted[path] = hashPair
+			if keep(path) {
+				kept = append(kept, path)
+			} else {
+				removed++
+			}
+		}
+		if len(kept) == 0 {
+			delete(hashMap, hashPair)
+		} else {
+			hashMap[hashPair] = kept
 		}
 	}
-	return inverted
+	return removed
+}
+
+// OrphanFolders returns the folders of the database files that no longer
+// exist on disk, sorted, only the topmost when a whole tree is gone. Folders
+// that cannot be checked (e.g. permission denied) are not orphans. Missing
+// folders whose nearest existing ancestor is an empty folder are returned
+// apart as unmounted: it is likely the mount point of a disk not mounted now.
+func OrphanFolders(hashMap map[utils.HashPair][]string) (orphans []string, unmounted []string) {
+	checked := make(map[string]bool) //folder -> missing
+	var missing []string
+	EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		dir := filepath.Dir(path)
+		if _, ok := checked[dir]; !ok {
+			_, err := os.Stat(dir)
+			checked[dir] = errors.Is(err, fs.ErrNotExist)
+			if checked[dir] {
+				missing = append(missing, dir)
+			}
+		}
+	})
+	sort.Strings(missing)
+	for _, dir := range missing {
+		if utils.IsUnderAny(dir, orphans) || utils.IsUnderAny(dir, unmounted) {
+			continue
+		}
+		if underEmptyFolder(dir) {
+			unmounted = append(unmounted, dir)
+		} else {
+			orphans = append(orphans, dir)
+		}
+	}
+	return orphans, unmounted
+}
+
+// underEmptyFolder tells if the nearest existing ancestor of the missing
+// folder dir is an empty folder.
+func underEmptyFolder(dir string) bool {
+	for parent := filepath.Dir(dir); ; parent = filepath.Dir(parent) {
+		entries, err := os.ReadDir(parent)
+		if err == nil {
+			return len(entries) == 0
+		}
+		if !errors.Is(err, fs.ErrNotExist) || parent == filepath.Dir(parent) {
+			return false
+		}
+	}
+}
+
+// Checkpoint records where an interrupted update stopped: the root being
+// scanned and the last folder of that root whose files, and those of the
+// folders before it, were all hashed.
+type Checkpoint struct {
+	Root   string
+	Folder string //empty when no folder of Root was completed
+}
+
+// duplitoFile returns the path of a file in ~/.duplito
+func duplitoFile(name string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".duplito", name), nil
+}
+
+func checkpointPath() (string, error) {
+	return duplitoFile("checkpoint.gob")
+}
+
+// LoadCheckpoint reads ~/.duplito/checkpoint.gob, returns nil if there is none.
+func LoadCheckpoint() (*Checkpoint, error) {
+	configPath, err := checkpointPath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(configPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(configPath), err)
+	}
+	defer file.Close()
+
+	var checkpoint Checkpoint
+	if err := gob.NewDecoder(file).Decode(&checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filepath.Base(configPath), err)
+	}
+	return &checkpoint, nil
+}
+
+// SaveCheckpoint writes ~/.duplito/checkpoint.gob, the folder already exists
+// because the database is saved first.
+func SaveCheckpoint(checkpoint Checkpoint) error {
+	configPath, err := checkpointPath()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(configPath), err)
+	}
+	defer file.Close()
+
+	if err := gob.NewEncoder(file).Encode(checkpoint); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(configPath), err)
+	}
+	return nil
+}
+
+// RemoveCheckpoint deletes the checkpoint after a complete update.
+func RemoveCheckpoint() error {
+	configPath, err := checkpointPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", filepath.Base(configPath), err)
+	}
+	return nil
+}
+
+// Metadata describes the last complete update of the database.
+type Metadata struct {
+	LastUpdate     time.Time
+	FullHash       bool //-U, otherwise quick hash
+	SparseAware    bool
+	IncludeXattrs  bool     //extended attributes folded into the hash
+	MaxHashBytes   int64    //full hash capped to the first bytes, 0 unlimited
+	TinyMultiplier int64    //quick hash full read threshold in areas, 0 for the databases of older versions
+	FullHashAbove  int64    //quick hash database with the files bigger than this fully hashed, 0 none
+	Roots          []string //absolute paths scanned
+}
+
+// QuickHashed tells if the files of filesize were hashed with the quick hash,
+// nil metadata (databases of older versions) means the default quick hash.
+func (m *Metadata) QuickHashed(filesize int64) bool {
+	return m == nil || (!m.FullHash && (m.FullHashAbove == 0 || filesize <= m.FullHashAbove))
+}
+
+// ContentHashed tells if the hashes of the database cover the whole content of
+// the files (-U without --max-hash-bytes), only then a match can feed deletions.
+func (m *Metadata) ContentHashed() bool {
+	return m != nil && m.FullHash && m.MaxHashBytes == 0
+}
+
+// QuickTinyMultiplier returns the tiny multiplier the quick hashes of the
+// database were computed with, the default when unknown (nil metadata).
+func (m *Metadata) QuickTinyMultiplier() int64 {
+	if m == nil || m.TinyMultiplier == 0 {
+		return utils.DefaultTinyMultiplier
+	}
+	return m.TinyMultiplier
+}
+
+// LoadMetadata reads ~/.duplito/metadata.gob, returns nil if there is none
+// (databases of older versions).
+func LoadMetadata() (*Metadata, error) {
+	configPath, err := duplitoFile("metadata.gob")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(configPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(configPath), err)
+	}
+	defer file.Close()
+
+	var metadata Metadata
+	if err := gob.NewDecoder(file).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filepath.Base(configPath), err)
+	}
+	return &metadata, nil
+}
+
+// SaveMetadata writes ~/.duplito/metadata.gob, the folder already exists
+// because the database is saved first.
+func SaveMetadata(metadata Metadata) error {
+	configPath, err := duplitoFile("metadata.gob")
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(configPath), err)
+	}
+	defer file.Close()
+
+	if err := gob.NewEncoder(file).Encode(metadata); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(configPath), err)
+	}
+	return nil
+}
+
+// RenameFolder rewrites the paths under oldRoot as paths under newRoot, keeping
+// their hashes, for folders moved after the last update. Roots must be absolute
+// and clean. A renamed path already listed in its group (newRoot indexed too)
+// is kept once. Returns the number of renamed paths.
+func RenameFolder(hashMap map[utils.HashPair][]string, oldRoot string, newRoot string) int {
+	renamed := 0
+	for hashPair, paths := range hashMap {
+		groupRenamed := false
+		for i, path := range paths {
+			if utils.IsUnder(path, oldRoot) {
+				paths[i] = filepath.Join(newRoot, strings.TrimPrefix(path, oldRoot))
+				renamed++
+				groupRenamed = true
+			}
+		}
+		if groupRenamed {
+			hashMap[hashPair] = uniquePaths(paths)
+		}
+	}
+	return renamed
+}
+
+// uniquePaths drops the repeated paths, in place, keeping the first of each.
+func uniquePaths(paths []string) []string {
+	seen := make(map[string]bool, len(paths))
+	unique := paths[:0]
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
+
+// CheckMap verifies the consistency of the database and returns a description
+// of each problem found: groups without paths, paths not absolute and clean or
+// listed more than once, negative sizes, and unhashed sizes (a file with a
+// unique size) shared by more files.
+func CheckMap(hashMap map[utils.HashPair][]string) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	hashedSizes := make(map[int64]bool)
+	for hashPair := range hashMap {
+		if hashPair.Hash != "" && !utils.IsSymlinkHash(hashPair.Hash) {
+			hashedSizes[hashPair.Filesize] = true
+		}
+	}
+	for hashPair, paths := range hashMap {
+		if len(paths) == 0 {
+			problems = append(problems, fmt.Sprintf("empty group for size %d hash %q", hashPair.Filesize, hashPair.Hash))
+		}
+		if hashPair.Filesize < 0 {
+			problems = append(problems, fmt.Sprintf("negative size %d", hashPair.Filesize))
+		}
+		if hashPair.Hash == "" && (len(paths) > 1 || hashedSizes[hashPair.Filesize]) {
+			problems = append(problems, fmt.Sprintf("size %d is not hashed but it is shared by more files", hashPair.Filesize))
+		}
+		for _, path := range paths {
+			if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+				problems = append(problems, fmt.Sprintf("path %q is not absolute and clean", path))
+			}
+			if seen[path] {
+				problems = append(problems, fmt.Sprintf("path %q is listed more than once", path))
+			}
+			seen[path] = true
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// EachFile calls fn for every file of the database with its (size, hash)
+// pair, in no particular order. It is ForEachFile for callbacks that cannot fail.
+func EachFile(hashMap map[utils.HashPair][]string, fn func(path string, hashPair utils.HashPair)) {
+	for hashPair, paths := range hashMap {
+		for _, path := range paths {
+			fn(path, hashPair)
+		}
+	}
+}
+
+// ForEachFile calls fn for every file of the database with its (size, hash)
+// pair, in no particular order. The iteration stops at the first error returned
+// by fn, and that error is returned.
+func ForEachFile(hashMap map[utils.HashPair][]string, fn func(path string, hashPair utils.HashPair) error) error {
+	for hashPair, paths := range hashMap {
+		for _, path := range paths {
+			if err := fn(path, hashPair); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PathsBySize returns all the catalogued files with the provided size,
+// whatever their hash.
+func PathsBySize(hashMap map[utils.HashPair][]string, size int64) []string {
+	var paths []string
+	for hashPair, hashPaths := range hashMap {
+		if hashPair.Filesize == size {
+			paths = append(paths, hashPaths...)
+		}
+	}
+	return paths
 }
@@ -13,6 +13,11 @@ type Stats struct {
 	SizeofFiles      int64
 	SizeofDupFiles   int64
 	SizeIgnoredFiles int64
+	SizeReclaimable  int64 //duplicate bytes that can be removed, keeping at least one copy
+
+	NumSkippedSymlinks   int64 //symbolic links not followed nor listed
+	NumSkippedNonRegular int64 //devices, fifos, sockets
+	NumFilteredFiles     int64 //files counted but not listed because of -m or --grep
 }
 
 func (s *Stats) Reset() {
@@ -39,6 +44,40 @@ func (s *Stats) AddIgnoredFile(size int64) {
 	s.SizeIgnoredFiles += size
 }
 
+// Merge adds the counters of other, e.g. a folder, to s
+func (s *Stats) Merge(other Stats) {
+	s.NumFiles += other.NumFiles
+	s.NumDupFiles += other.NumDupFiles
+	s.NumIgnoredFiles += other.NumIgnoredFiles
+	s.SizeofFiles += other.SizeofFiles
+	s.SizeofDupFiles += other.SizeofDupFiles
+	s.SizeIgnoredFiles += other.SizeIgnoredFiles
+	s.SizeReclaimable += other.SizeReclaimable
+	s.NumSkippedSymlinks += other.NumSkippedSymlinks
+	s.NumSkippedNonRegular += other.NumSkippedNonRegular
+	s.NumFilteredFiles += other.NumFilteredFiles
+}
+
+// AddSkippedSymlink records a symbolic link that was skipped
+func (s *Stats) AddSkippedSymlink() {
+	s.NumSkippedSymlinks++
+}
+
+// AddSkippedNonRegular records a special file (device, fifo, socket) that was skipped
+func (s *Stats) AddSkippedNonRegular() {
+	s.NumSkippedNonRegular++
+}
+
+// AddFilteredFile records a file counted in the statistics but not listed
+func (s *Stats) AddFilteredFile() {
+	s.NumFilteredFiles++
+}
+
+// AddReclaimable records duplicate bytes that could be removed
+func (s *Stats) AddReclaimable(size int64) {
+	s.SizeReclaimable += size
+}
+
 //Percentage of Duplicates files
 func (s *Stats) DupPerc() float32 {
 	return 100.0 * float32(s.NumDupFiles) / float32(s.NumFiles)
@@ -49,6 +88,14 @@ func (s *Stats) DupSizePerc() float32 {
 	return 100.0 * float32(s.SizeofDupFiles) / float32(s.SizeofFiles)
 }
 
+// Percentage of reclaimable bytes over the filesize, 0 when there is no data
+func (s *Stats) ReclaimPerc() float32 {
+	if s.SizeofFiles == 0 {
+		return 0
+	}
+	return 100.0 * float32(s.SizeReclaimable) / float32(s.SizeofFiles)
+}
+
 //Percentage of Duplicates filesize
 func (s *Stats) StringSummary() string {
 	text := fmt.Sprintf("\tFILES:\t\t%-20dSIZE: %s\n\tDUPLICATES:\t%-9d [%5.1f%%]  DUP_SIZE: %-9s [%5.1f%%]\n\tIGNORED:\t%-20dIGN_SIZE %s\n",
@@ -57,3 +104,90 @@ func (s *Stats) StringSummary() string {
 		s.NumIgnoredFiles, utils.RepresentBytes(s.SizeIgnoredFiles))
 	return text
 }
+
+// SkippedSummary returns the line with the entries that were skipped or not listed
+func (s *Stats) SkippedSummary() string {
+	return fmt.Sprintf("\tSKIPPED:\tSYMLINKS: %-10dNON_REGULAR: %-10dFILTERED: %d\n",
+		s.NumSkippedSymlinks, s.NumSkippedNonRegular, s.NumFilteredFiles)
+}
+
+// HardlinkStats tracks files that share an inode with an already counted file.
+type HardlinkStats struct {
+	NumLinkedFiles int64 // files with more than one link
+	NumInodes      int64 // distinct inodes among linked files
+	SizeSaved      int64 // bytes not using disk space because the inode was already counted
+	seen           map[utils.FileID]bool
+}
+
+// AddLinkedFile records a file with link count > 1, the first path of an inode
+// is counted as using disk space, the following ones as saved space.
+func (h *HardlinkStats) AddLinkedFile(id utils.FileID, size int64) {
+	if h.seen == nil {
+		h.seen = make(map[utils.FileID]bool)
+	}
+	h.NumLinkedFiles++
+	if h.seen[id] {
+		h.SizeSaved += size
+		return
+	}
+	h.seen[id] = true
+	h.NumInodes++
+}
+
+// StringSummary reports saved space compared to the apparent size of all files
+func (h *HardlinkStats) StringSummary(apparentSize int64) string {
+	text := fmt.Sprintf("\tLINKED FILES:\t%-20dINODES: %d\n\tSAVED SIZE:\t%-20sAPPARENT: %s  ON DISK: %s\n",
+		h.NumLinkedFiles, h.NumInodes,
+		utils.RepresentBytes(h.SizeSaved),
+		utils.RepresentBytes(apparentSize), utils.RepresentBytes(apparentSize-h.SizeSaved))
+	return text
+}
+
+// bucket upper limits (exclusive) of SizeHistogram, the last bucket has no limit
+var histogramLimits = [...]int64{1000000, 100000000, 1000000000}
+var histogramLabels = [...]string{"< 1 MB", "1 MB - 100 MB", "100 MB - 1 GB", ">= 1 GB"}
+
+// SizeHistogram buckets duplicate files by their file size
+type SizeHistogram struct {
+	NumFiles [len(histogramLabels)]int64
+	Size     [len(histogramLabels)]int64
+}
+
+// Add records a duplicate file in the bucket for its size
+func (h *SizeHistogram) Add(size int64) {
+	bucket := len(histogramLimits)
+	for i, limit := range histogramLimits {
+		if size < limit {
+			bucket = i
+			break
+		}
+	}
+	h.NumFiles[bucket]++
+	h.Size[bucket] += size
+}
+
+// Merge adds the buckets of other to h
+func (h *SizeHistogram) Merge(other SizeHistogram) {
+	for i := range h.NumFiles {
+		h.NumFiles[i] += other.NumFiles[i]
+		h.Size[i] += other.Size[i]
+	}
+}
+
+// StringTable renders one line per bucket, with the share of duplicate bytes
+func (h *SizeHistogram) StringTable() string {
+	var total int64
+	for _, size := range h.Size {
+		total += size
+	}
+	text := ""
+	for i, label := range histogramLabels {
+		perc := float32(0)
+		if total > 0 {
+			perc = 100.0 * float32(h.Size[i]) / float32(total)
+		}
+		text += fmt.Sprintf("\t%-16sDUPLICATES: %-9d DUP_SIZE: %-9s [%5.1f%%]\n",
+			label, h.NumFiles[i], utils.RepresentBytes(h.Size[i]), perc)
+	}
+	return text
+}
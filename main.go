@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
 	cfg "github.com/ftarlao/duplito/config"
 	config "github.com/ftarlao/duplito/config"
@@ -31,20 +39,216 @@ func customUsage() {
 	fmt.Fprintf(os.Stderr, "  -r, --recurse         Recurse into subdirectories (auto with -u or -U).\n")
 	fmt.Fprintf(os.Stderr, "  -u, --update          Update hash database using quick-partial hash (implies -r).\n")
 	fmt.Fprintf(os.Stderr, "                        If no paths, defaults to user home (or / for root).\n")
+	fmt.Fprintf(os.Stderr, "  --tiny-multiplier N   With -u, files up to N hash areas (2MB each, default 10) are read\n")
+	fmt.Fprintf(os.Stderr, "                        fully, bigger ones are sampled: lower on SSDs, higher on HDDs to\n")
+	fmt.Fprintf(os.Stderr, "                        avoid seeks. The hashes change, do not mix catalogs.\n")
+	fmt.Fprintf(os.Stderr, "  --full-hash-above N   With -u, files bigger than N bytes get the full hash, the others the\n")
+	fmt.Fprintf(os.Stderr, "                        quick one. The hashes change, do not mix catalogs.\n")
+	fmt.Fprintf(os.Stderr, "  --noatime             With -u/-U, reads the files without updating their access time\n")
+	fmt.Fprintf(os.Stderr, "                        (O_NOATIME, Linux). Files not owned by the user are read normally.\n")
+	fmt.Fprintf(os.Stderr, "  --resume-hash         With -u/-U, a file with a read error gets a \"partial:N:md5\" hash of\n")
+	fmt.Fprintf(os.Stderr, "                        its first N readable bytes instead of failing (dying disks).\n")
+	fmt.Fprintf(os.Stderr, "  --error-log FILE      With -u/-U, writes the files that could not be read, with the\n")
+	fmt.Fprintf(os.Stderr, "                        reason, to FILE. They are always listed at the end of the update.\n")
 	fmt.Fprintf(os.Stderr, "  -U, --UPDATE          Update hash database using full file hash (implies -r).\n")
+	fmt.Fprintf(os.Stderr, "  --sparse-aware        With -U, hashes only allocated data (SEEK_DATA/SEEK_HOLE on Linux)\n")
+	fmt.Fprintf(os.Stderr, "                        and skips zero blocks, sparse images compare by content. The\n")
+	fmt.Fprintf(os.Stderr, "                        hashes differ from a plain -U, do not mix catalogs.\n")
+	fmt.Fprintf(os.Stderr, "  --max-hash-bytes N    With -U, hashes at most the first N bytes of each file (huge disk\n")
+	fmt.Fprintf(os.Stderr, "                        images), files with the same size and first N bytes are duplicates.\n")
+	fmt.Fprintf(os.Stderr, "                        The hashes differ from a plain -U, do not mix catalogs.\n")
+	fmt.Fprintf(os.Stderr, "  --include-xattrs      With -u/-U, folds the extended attributes into the hash (Linux only):\n")
+	fmt.Fprintf(os.Stderr, "                        same content with different attributes is NOT a duplicate.\n")
+	fmt.Fprintf(os.Stderr, "  --dry-run             With -u/-U, walks the paths and reports new/changed/unchanged files\n")
+	fmt.Fprintf(os.Stderr, "                        (by size) and the files to hash, without touching the database.\n")
+	fmt.Fprintf(os.Stderr, "                        A file edited without changing its size is reported unchanged.\n")
+	fmt.Fprintf(os.Stderr, "  --exclude PATTERN     Skips the files and folders whose name matches the shell pattern\n")
+	fmt.Fprintf(os.Stderr, "                        (e.g. '*.tmp', node_modules), repeatable. Updates and listings.\n")
+	fmt.Fprintf(os.Stderr, "  --exclude-os-junk     Excludes the clutter created by operating systems: Thumbs.db,\n")
+	fmt.Fprintf(os.Stderr, "                        desktop.ini, .DS_Store, ._*, __MACOSX, $RECYCLE.BIN and more.\n")
+	fmt.Fprintf(os.Stderr, "  --estimate-only       Walks the paths without reading files nor using the database and\n")
+	fmt.Fprintf(os.Stderr, "                        reports an upper bound of the reclaimable space (files sharing sizes).\n")
+	fmt.Fprintf(os.Stderr, "  --resume-from-checkpoint With -u/-U, continues an interrupted update (errors or Ctrl-C)\n")
+	fmt.Fprintf(os.Stderr, "                        with the same paths, folders already done are not hashed again.\n")
+	fmt.Fprintf(os.Stderr, "  --hash-symlinks       Catalogs symbolic links (skipped by default) using their target path\n")
+	fmt.Fprintf(os.Stderr, "                        as content, links to the same target are duplicates. Use it also\n")
+	fmt.Fprintf(os.Stderr, "                        when listing. Links are not followed.\n")
+	fmt.Fprintf(os.Stderr, "  --min-index-size      With -u/-U, files smaller than the provided size in bytes are not\n")
+	fmt.Fprintf(os.Stderr, "                        hashed nor added to the database (listed as not in database).\n")
+	fmt.Fprintf(os.Stderr, "  --skip-magic HEX,...  With -u/-U, files starting with one of the comma separated hex\n")
+	fmt.Fprintf(os.Stderr, "                        signatures (e.g. 89504e47 for PNG) are not added to the database.\n")
+	fmt.Fprintf(os.Stderr, "  --max-files N         With -u/-U, stops the walk once N files are found, the database\n")
+	fmt.Fprintf(os.Stderr, "                        keeps the partial results (default: 0, unlimited).\n")
+	fmt.Fprintf(os.Stderr, "  --max-runtime TIME    With -u/-U, stops the update after TIME (e.g. 30m, 2h), the files\n")
+	fmt.Fprintf(os.Stderr, "                        already hashed are saved and a checkpoint allows to resume.\n")
+	fmt.Fprintf(os.Stderr, "  --progress-total-from-db With -u/-U, shows the progress percentage estimated from the\n")
+	fmt.Fprintf(os.Stderr, "                        files in the database, exact once the walk completes.\n")
 	fmt.Fprintf(os.Stderr, "  -d, --duplicates      Only shows the duplicates in filelist (summary not affected).\n")
 	fmt.Fprintf(os.Stderr, "                        If no paths, defaults to user home (or / for root).\n")
+	fmt.Fprintf(os.Stderr, "  --unindexed-only      Only lists the files missing from the database and their folders,\n")
+	fmt.Fprintf(os.Stderr, "                        to check what the last update missed (summary not affected).\n")
 	fmt.Fprintf(os.Stderr, "  -m, --min-file-size 	Only lists files with size greater or equal, than the provided filesize\n")
 	fmt.Fprintf(os.Stderr, "                        in bytes. Directory and overall summaries are not affected.\n")
+	fmt.Fprintf(os.Stderr, "  --max-catalog-age AGE Warns, when listing, if the last complete update is older than AGE\n")
+	fmt.Fprintf(os.Stderr, "                        (e.g. 7d, 12h), the report may be stale.\n")
+	fmt.Fprintf(os.Stderr, "  --report-header       Starts the listing with its provenance: time, version, paths,\n")
+	fmt.Fprintf(os.Stderr, "                        arguments and hash mode of the database (a JSON line with --json-all).\n")
+	fmt.Fprintf(os.Stderr, "  --grep REGEXP         Only lists the files whose full path matches the regular expression\n")
+	fmt.Fprintf(os.Stderr, "                        (a plain word matches as substring). Summaries are not affected.\n")
+	fmt.Fprintf(os.Stderr, "  -f, --format          Prints each file line using a template, e.g. '{status} {size} {path}'.\n")
+	fmt.Fprintf(os.Stderr, "                        Placeholders: {filename} {path} {size} {hsize} {hash} {status}.\n")
+	fmt.Fprintf(os.Stderr, "                        Folder headers are not printed (alias --output-template).\n")
+	fmt.Fprintf(os.Stderr, "  -0, --null-terminated Ends each file line with NUL instead of newline, like find -print0,\n")
+	fmt.Fprintf(os.Stderr, "                        with --format (default '{path}'). Otherwise control characters in\n")
+	fmt.Fprintf(os.Stderr, "                        displayed names are escaped (\\n, \\x1b, ...).\n")
+	fmt.Fprintf(os.Stderr, "  --filename-encoding   Encoding of the names that are not valid UTF-8 (old drives), for\n")
+	fmt.Fprintf(os.Stderr, "                        display: utf8 (default, invalid bytes shown as \\xNN) or latin1.\n")
+	fmt.Fprintf(os.Stderr, "                        The database keeps the names as they are on disk.\n")
+	fmt.Fprintf(os.Stderr, "  --zero-byte-duplicates Lists the zero size files as duplicates of each other instead\n")
+	fmt.Fprintf(os.Stderr, "                        of ZERO SIZE, to find and remove stray empty files.\n")
+	fmt.Fprintf(os.Stderr, "  --show-hash           Appends the first 12 characters of the database hash to each\n")
+	fmt.Fprintf(os.Stderr, "                        file line, files with a unique size are not hashed.\n")
+	fmt.Fprintf(os.Stderr, "  --alias               Displays a root with a friendly name, e.g. /mnt/usb-8f3a=PHOTOS,\n")
+	fmt.Fprintf(os.Stderr, "                        repeatable. The database keeps the real paths.\n")
+	fmt.Fprintf(os.Stderr, "  --hash-verify-on-match Before reporting a duplicate, computes the full hash of each copy\n")
+	fmt.Fprintf(os.Stderr, "                        and drops the copies whose content differs (useful with -u catalogs).\n")
+	fmt.Fprintf(os.Stderr, "  --rehash-on-collision N Like --hash-verify-on-match, only for the duplicate groups with more\n")
+	fmt.Fprintf(os.Stderr, "                        than N copies. The overall stats report the groups split.\n")
+	fmt.Fprintf(os.Stderr, "  --report-hardlink-savings Reports, after the overall summary, the files sharing an inode\n")
+	fmt.Fprintf(os.Stderr, "                        (hardlinks) and the disk space they save, each inode counts once.\n")
+	fmt.Fprintf(os.Stderr, "  --parallel-folders    Number of folders classified concurrently when listing (default: 1),\n")
+	fmt.Fprintf(os.Stderr, "                        the output keeps the folder order.\n")
+	fmt.Fprintf(os.Stderr, "  --stream-chunk N      Lists huge folders N files at a time to bound memory: files are\n")
+	fmt.Fprintf(os.Stderr, "                        sorted within each chunk, the folder summary follows its files and\n")
+	fmt.Fprintf(os.Stderr, "                        the folder filters (-p, -b) apply only to it (default: 0, disabled).\n")
+	fmt.Fprintf(os.Stderr, "  --limit-per-folder N  Lists at most N files per folder, the duplicates first, followed by\n")
+	fmt.Fprintf(os.Stderr, "                        the number left out. The folder stats count every file.\n")
+	fmt.Fprintf(os.Stderr, "  --fadvise MODE        Page cache advice for the hashed files (Linux): none (default),\n")
+	fmt.Fprintf(os.Stderr, "                        sequential (larger read-ahead) or dontneed (sequential, and the\n")
+	fmt.Fprintf(os.Stderr, "                        pages read are dropped to keep the cache for the working set).\n")
+	fmt.Fprintf(os.Stderr, "  --max-open-files      Max number of files opened at the same time by the hashing threads,\n")
+	fmt.Fprintf(os.Stderr, "                        independent of -t (default: 0, unlimited).\n")
+	fmt.Fprintf(os.Stderr, "  --size-histogram      Reports, after the overall summary, the duplicates bucketed by\n")
+	fmt.Fprintf(os.Stderr, "                        file size (<1MB, 1-100MB, 100MB-1GB, >=1GB).\n")
+	fmt.Fprintf(os.Stderr, "  --json-all            Lists every file as a JSON line (version, path, size, hash, status,\n")
+	fmt.Fprintf(os.Stderr, "                        duplicates), version is the schema version, bumped when fields change,\n")
+	fmt.Fprintf(os.Stderr, "                        summaries are not printed.\n")
+	fmt.Fprintf(os.Stderr, "  --check               Verifies that the database can be read and is consistent, then exits\n")
+	fmt.Fprintf(os.Stderr, "                        with status 1 if problems are found.\n")
+	fmt.Fprintf(os.Stderr, "  --trim-db-to          Removes from the database all files outside the provided comma\n")
+	fmt.Fprintf(os.Stderr, "                        separated roots (e.g. /data,/photos), then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --orphan-folders      Lists the folders of the database that no longer exist on disk\n")
+	fmt.Fprintf(os.Stderr, "                        (the topmost of each missing tree), then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --remove-orphan-folders Like --orphan-folders, and removes their files from the database\n")
+	fmt.Fprintf(os.Stderr, "                        after confirmation (or --yes). Folders under an empty folder, e.g.\n")
+	fmt.Fprintf(os.Stderr, "                        the mount point of an unmounted disk, are kept.\n")
+	fmt.Fprintf(os.Stderr, "  --move-folder OLD=NEW Rewrites in the database the paths under OLD as paths under NEW,\n")
+	fmt.Fprintf(os.Stderr, "                        keeping the hashes (for moved folders, no rehashing), then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --diff                Compares the two provided files and reports the offset of the\n")
+	fmt.Fprintf(os.Stderr, "                        first differing byte (useful for same-size not duplicate files).\n")
+	fmt.Fprintf(os.Stderr, "  --diff-db OLD NEW     Compares two saved copies of filemap.gob and reports the files added,\n")
+	fmt.Fprintf(os.Stderr, "                        removed and changed (size or hash) between them, no rescan.\n")
+	fmt.Fprintf(os.Stderr, "  --color-scheme        Colors of the file list: auto, default, colorblind (blue/orange and\n")
+	fmt.Fprintf(os.Stderr, "                        marks) or mono. auto uses default on a terminal, mono otherwise.\n")
+	fmt.Fprintf(os.Stderr, "  --color-sections S    Parts of the file list colored: all (default), files (plain folder\n")
+	fmt.Fprintf(os.Stderr, "                        headers and summaries) or headers (plain file lines).\n")
+	fmt.Fprintf(os.Stderr, "  --size-collisions N   Reports the N filesizes shared by most files in the database, with\n")
+	fmt.Fprintf(os.Stderr, "                        their number of distinct contents, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --files-of-size N     Lists the files of the database with a size of N bytes, whatever\n")
+	fmt.Fprintf(os.Stderr, "                        their content (e.g. a size from --size-collisions), then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --report-duplicate-dirs Reports the groups of folders whose files have the same names\n")
+	fmt.Fprintf(os.Stderr, "                        and contents in the database (subfolders not included), then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --dir-pairs N         Reports the N pairs of folders sharing the most duplicate bytes in the\n")
+	fmt.Fprintf(os.Stderr, "                        database, with the share of each folder files, then exits. Groups\n")
+	fmt.Fprintf(os.Stderr, "                        spread over more than 100 folders are skipped and counted.\n")
+	fmt.Fprintf(os.Stderr, "  --containing DIR      Reports every duplicate group having a copy under DIR (comma\n")
+	fmt.Fprintf(os.Stderr, "                        separated list allowed), with the copies elsewhere, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --case-collisions     Reports the files in the database whose paths differ only by case\n")
+	fmt.Fprintf(os.Stderr, "                        (collide on case-insensitive filesystems), then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --recompute-stats-from-db Prints the overall duplicate statistics of the database alone,\n")
+	fmt.Fprintf(os.Stderr, "                        without reading the filesystem.\n")
+	fmt.Fprintf(os.Stderr, "  --check-stdin         Reads stdin to the end and lists the files in the database with the\n")
+	fmt.Fprintf(os.Stderr, "                        same content, exit status 1 when there is none, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --size-tolerance P    Fuzzy report of similar files (NOT duplicates) under the provided\n")
+	fmt.Fprintf(os.Stderr, "                        paths: sizes within P%% and same first 4096 bytes, e.g. document\n")
+	fmt.Fprintf(os.Stderr, "                        revisions or rotated logs, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --detect-truncated    Reports the files under the provided paths (all the database when\n")
+	fmt.Fprintf(os.Stderr, "                        no paths) whose content is the beginning of a bigger one, e.g.\n")
+	fmt.Fprintf(os.Stderr, "                        interrupted downloads (at least 4096 bytes), then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --verify              Hashes again the files of the database under the provided paths (all\n")
+	fmt.Fprintf(os.Stderr, "                        the database when no paths) and reports those changed or missing.\n")
+	fmt.Fprintf(os.Stderr, "                        Meaningful with a -U database, unique size files are size checked.\n")
+	fmt.Fprintf(os.Stderr, "  --fail-on-changed     With --verify, exit status 1 when any file changed or is missing.\n")
+	fmt.Fprintf(os.Stderr, "  --largest N           Reports the N biggest files of the database under the provided paths\n")
+	fmt.Fprintf(os.Stderr, "                        (all the database when no paths), duplicate or not, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --compare-hash-modes N Hashes with both -u and -U the files of the N biggest filesizes\n")
+	fmt.Fprintf(os.Stderr, "                        shared in the database, reports the quick hash groups split by\n")
+	fmt.Fprintf(os.Stderr, "                        the full hash, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --html FILE           Writes an HTML report with the statistics and the duplicate groups\n")
+	fmt.Fprintf(os.Stderr, "                        having a copy under the provided paths (all groups when no paths),\n")
+	fmt.Fprintf(os.Stderr, "                        then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --print-duplicates-null Prints only the paths of the removable copies under the provided\n")
+	fmt.Fprintf(os.Stderr, "                        paths (all but the first copy of each group, like --gen-script),\n")
+	fmt.Fprintf(os.Stderr, "                        NUL terminated for xargs -0, then exits. Requires a -U database.\n")
+	fmt.Fprintf(os.Stderr, "  --flat-groups         Prints the duplicate groups having a copy under the provided paths\n")
+	fmt.Fprintf(os.Stderr, "                        (all groups when no paths), one path per line, for awk, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --group-separator S   With --flat-groups, line printed after each group (default: blank).\n")
+	fmt.Fprintf(os.Stderr, "  --group-marker        With --flat-groups, starts each group with a line\n")
+	fmt.Fprintf(os.Stderr, "                        '# group N hash=H size=BYTES copies=N'.\n")
+	fmt.Fprintf(os.Stderr, "  --tsv                 Prints hash<TAB>size<TAB>path for every file of the database under the\n")
+	fmt.Fprintf(os.Stderr, "                        provided paths (all when no paths), then exits. In the path \\, tab,\n")
+	fmt.Fprintf(os.Stderr, "                        newline and CR are written \\\\, \\t, \\n and \\r. Empty hash: unique size.\n")
+	fmt.Fprintf(os.Stderr, "  --rmlint-json         Prints the duplicate groups having a copy under the provided paths\n")
+	fmt.Fprintf(os.Stderr, "                        (all groups when no paths) as an rmlint json-dump, the copy kept by\n")
+	fmt.Fprintf(os.Stderr, "                        --gen-script is the original, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --gen-script FILE     Writes a shell script with commented rm/ln lines for each duplicate\n")
+	fmt.Fprintf(os.Stderr, "                        group having a copy under the provided paths (all groups when no\n")
+	fmt.Fprintf(os.Stderr, "                        paths), keeping the first copy, then exits. Nothing is deleted.\n")
+	fmt.Fprintf(os.Stderr, "  --dedupe-simulation   Reports the disk used by the database files before and after removing\n")
+	fmt.Fprintf(os.Stderr, "                        (or hardlinking) the duplicates under the provided paths, keeping the\n")
+	fmt.Fprintf(os.Stderr, "                        copy of --gen-script, hardlinks counted once. Nothing is changed.\n")
+	fmt.Fprintf(os.Stderr, "  --keep-newest         DELETES FILES: for each duplicate group with copies under the provided\n")
+	fmt.Fprintf(os.Stderr, "                        paths keeps the newest copy and deletes the older ones under the\n")
+	fmt.Fprintf(os.Stderr, "                        paths, after checking size and content, then updates the database.\n")
+	fmt.Fprintf(os.Stderr, "                        Asks confirmation unless --yes.\n")
+	fmt.Fprintf(os.Stderr, "  --yes                 Does not ask confirmation for --keep-newest.\n")
+	fmt.Fprintf(os.Stderr, "  --action-log FILE     With --keep-newest, records the planned deletions in FILE (new file)\n")
+	fmt.Fprintf(os.Stderr, "                        before deleting, and each deletion once done.\n")
+	fmt.Fprintf(os.Stderr, "  --resume-action FILE  Continues the deletions of an interrupted --keep-newest recorded\n")
+	fmt.Fprintf(os.Stderr, "                        with --action-log, with the same checks, then exits.\n")
+	fmt.Fprintf(os.Stderr, "  --keep-under DIR      Master copies, repeatable: with --keep-newest, --gen-script and\n")
+	fmt.Fprintf(os.Stderr, "                        --print-duplicates-null the copies under DIR are never removed and\n")
+	fmt.Fprintf(os.Stderr, "                        one of them is the kept copy of the group.\n")
+	fmt.Fprintf(os.Stderr, "  --remove-empty-dirs   With --keep-newest, removes the folders under the paths left empty\n")
+	fmt.Fprintf(os.Stderr, "                        by the deletions (the paths themselves are kept).\n")
+	fmt.Fprintf(os.Stderr, "  --log-level           Diagnostics written to stderr: debug, info, warn or error\n")
+	fmt.Fprintf(os.Stderr, "                        (default: info). debug traces the folders walked.\n")
+	fmt.Fprintf(os.Stderr, "  --print-config        Prints the options resolved from the command line as JSON, then\n")
+	fmt.Fprintf(os.Stderr, "                        exits.\n")
+	fmt.Fprintf(os.Stderr, "  --cpuprofile FILE     Writes a pprof CPU profile of the run to FILE, for performance work\n")
+	fmt.Fprintf(os.Stderr, "                        (go tool pprof).\n")
+	fmt.Fprintf(os.Stderr, "  --memprofile FILE     Writes a pprof heap profile to FILE at exit.\n")
 	fmt.Fprintf(os.Stderr, "  -i, --ignore-errors   Ignore unreadable/inaccessible files.\n")
-	fmt.Fprintf(os.Stderr, "  -t, --threads         Number of concurrent hashing threads (default: 3).\n\n")
+	fmt.Fprintf(os.Stderr, "  --max-error-rate      With -u/-U, skips unreadable files (implies -i) but stops the update\n")
+	fmt.Fprintf(os.Stderr, "                        when more than the specified percentage of files fails, e.g. a\n")
+	fmt.Fprintf(os.Stderr, "                        failing disk (checked after 100 files, default: 0, disabled).\n")
+	fmt.Fprintf(os.Stderr, "  --single-thread       Debug mode: walks, hashes and collects one file at a time in walk\n")
+	fmt.Fprintf(os.Stderr, "                        order (ignores -t), for reproducible runs and bug reports.\n")
+	fmt.Fprintf(os.Stderr, "  -t, --threads         Number of concurrent hashing threads, 0 uses one thread per CPU\n")
+	fmt.Fprintf(os.Stderr, "                        (default: 3).\n\n")
 	fmt.Fprintf(os.Stderr, "  -s, --summary         Display only 'per' directory summaries and the final overall\n")
 	fmt.Fprintf(os.Stderr, "                        summary, with statistics.\n")
+	fmt.Fprintf(os.Stderr, "  --summary-by-root     With more paths, prints the files, duplicates and reclaimable bytes\n")
+	fmt.Fprintf(os.Stderr, "                        of each path before the overall summary.\n")
 	fmt.Fprintf(os.Stderr, "  -o, --overall         Display only the final overall summary with statistics.\n\n")
 	fmt.Fprintf(os.Stderr, "  -p, --min-dir-perc    Visualizes summary and file list only for folders with a percentage\n")
 	fmt.Fprintf(os.Stderr, "                        of duplicates greater than the specified value (default: 0%%).\n")
 	fmt.Fprintf(os.Stderr, "  -b, --min-dir-bytes   Visualizes summary and file list only for folders with a file size\n")
 	fmt.Fprintf(os.Stderr, "                        of duplicates that exceeds the provided value (default: 0 byte).\n")
+	fmt.Fprintf(os.Stderr, "  --hide-clean          Hides the folders without duplicates, header and summary included.\n")
+	fmt.Fprintf(os.Stderr, "  --min-reclaim-perc    Visualizes summary and file list only for folders where the reclaimable\n")
+	fmt.Fprintf(os.Stderr, "                        bytes (duplicates removable keeping one copy) are at least the\n")
+	fmt.Fprintf(os.Stderr, "                        specified percentage of the folder size (default: 0%%).\n")
 
 	// Behavior Notes
 	fmt.Fprintf(os.Stderr, "Behavior:\n")
@@ -67,10 +271,73 @@ func init() {
 	flag.BoolVar(&opt.UpdateFlag, "update", false, "")
 	flag.BoolVar(&opt.IgnoreErrorsFlag, "i", false, "")
 	flag.BoolVar(&opt.IgnoreErrorsFlag, "ignore-errors", false, "")
+	flag.Float64Var(&opt.MaxErrorRate, "max-error-rate", 0, "")
 	flag.IntVar(&opt.NumThreads, "t", 3, "")       // Changed default to 3 threads
 	flag.IntVar(&opt.NumThreads, "threads", 3, "") // Changed default to 3 threads
+	flag.BoolVar(&opt.SingleThread, "single-thread", false, "")
 	flag.BoolVar(&opt.UpdateFullFlag, "U", false, "")
 	flag.BoolVar(&opt.UpdateFullFlag, "UPDATE", false, "")
+	flag.BoolVar(&opt.SparseAware, "sparse-aware", false, "")
+	flag.BoolVar(&opt.IncludeXattrs, "include-xattrs", false, "")
+	flag.Int64Var(&opt.TinyMultiplier, "tiny-multiplier", utils.DefaultTinyMultiplier, "")
+	flag.BoolVar(&opt.NoAtime, "noatime", false, "")
+	flag.Int64Var(&opt.FullHashAbove, "full-hash-above", 0, "")
+	flag.StringVar(&opt.ErrorLog, "error-log", "", "")
+	flag.BoolVar(&opt.ResumeHash, "resume-hash", false, "")
+	flag.Int64Var(&opt.MaxHashBytes, "max-hash-bytes", 0, "")
+	flag.BoolVar(&opt.DryRun, "dry-run", false, "")
+	flag.Var(&opt.Excludes, "exclude", "")
+	flag.BoolVar(&opt.ExcludeOSJunk, "exclude-os-junk", false, "")
+	flag.BoolVar(&opt.EstimateOnly, "estimate-only", false, "")
+	flag.BoolVar(&opt.HashSymlinks, "hash-symlinks", false, "")
+	flag.Int64Var(&opt.MinIndexBytes, "min-index-size", 0, "")
+	flag.StringVar(&opt.SkipMagic, "skip-magic", "", "")
+	flag.Int64Var(&opt.MaxFiles, "max-files", 0, "")
+	flag.BoolVar(&opt.ProgressFromDB, "progress-total-from-db", false, "")
+	flag.BoolVar(&opt.ResumeFlag, "resume-from-checkpoint", false, "")
+	flag.StringVar(&opt.ColorScheme, "color-scheme", "auto", "")
+	flag.StringVar(&opt.ColorSections, "color-sections", "all", "")
+	flag.IntVar(&opt.SizeCollisions, "size-collisions", 0, "")
+	flag.Int64Var(&opt.FilesOfSize, "files-of-size", -1, "")
+	flag.BoolVar(&opt.DuplicateDirs, "report-duplicate-dirs", false, "")
+	flag.IntVar(&opt.DirPairs, "dir-pairs", 0, "")
+	flag.BoolVar(&opt.CaseCollisions, "case-collisions", false, "")
+	flag.BoolVar(&opt.CheckStdin, "check-stdin", false, "")
+	flag.BoolVar(&opt.DBStats, "recompute-stats-from-db", false, "")
+	flag.StringVar(&opt.Containing, "containing", "", "")
+	flag.Float64Var(&opt.SizeTolerance, "size-tolerance", 0, "")
+	flag.IntVar(&opt.CompareHashModes, "compare-hash-modes", 0, "")
+	flag.StringVar(&opt.HTMLReport, "html", "", "")
+	flag.BoolVar(&opt.PrintDuplicatesNull, "print-duplicates-null", false, "")
+	flag.BoolVar(&opt.FlatGroups, "flat-groups", false, "")
+	flag.BoolVar(&opt.DetectTruncated, "detect-truncated", false, "")
+	flag.IntVar(&opt.Largest, "largest", 0, "")
+	flag.BoolVar(&opt.Verify, "verify", false, "")
+	flag.BoolVar(&opt.PrintConfig, "print-config", false, "")
+	flag.BoolVar(&opt.DedupeSimulation, "dedupe-simulation", false, "")
+	flag.BoolVar(&opt.RmlintJSON, "rmlint-json", false, "")
+	flag.BoolVar(&opt.TSV, "tsv", false, "")
+	flag.BoolVar(&opt.FailOnChanged, "fail-on-changed", false, "")
+	flag.StringVar(&opt.GroupSeparator, "group-separator", "", "")
+	flag.BoolVar(&opt.GroupMarker, "group-marker", false, "")
+	flag.StringVar(&opt.GenScript, "gen-script", "", "")
+	flag.BoolVar(&opt.KeepNewest, "keep-newest", false, "")
+	flag.BoolVar(&opt.Yes, "yes", false, "")
+	flag.StringVar(&opt.ActionLog, "action-log", "", "")
+	flag.StringVar(&opt.ResumeAction, "resume-action", "", "")
+	flag.Var(&opt.KeepUnder, "keep-under", "")
+	flag.BoolVar(&opt.RemoveEmptyDirs, "remove-empty-dirs", false, "")
+	flag.StringVar(&opt.LogLevel, "log-level", "info", "")
+	flag.StringVar(&opt.CPUProfile, "cpuprofile", "", "")
+	flag.StringVar(&opt.MemProfile, "memprofile", "", "")
+	flag.BoolVar(&opt.JSONAll, "json-all", false, "")
+	flag.BoolVar(&opt.CheckDB, "check", false, "")
+	flag.StringVar(&opt.TrimDBTo, "trim-db-to", "", "")
+	flag.BoolVar(&opt.OrphanFolders, "orphan-folders", false, "")
+	flag.BoolVar(&opt.RemoveOrphanFolders, "remove-orphan-folders", false, "")
+	flag.StringVar(&opt.MoveFolder, "move-folder", "", "")
+	flag.BoolVar(&opt.DiffFlag, "diff", false, "")
+	flag.BoolVar(&opt.DiffDB, "diff-db", false, "")
 	flag.BoolVar(&opt.Summary, "s", false, "")
 	flag.BoolVar(&opt.Summary, "summary", false, "") //only folder summary and final summary
 	flag.BoolVar(&opt.Overall, "o", false, "")       //only final summary
@@ -79,15 +346,62 @@ func init() {
 	flag.IntVar(&opt.MinDirPerc, "min-dir-perc", 0, "")
 	flag.Int64Var(&opt.MinDirBytes, "b", 0, "")
 	flag.Int64Var(&opt.MinDirBytes, "min-dir-bytes", 0, "")
+	flag.BoolVar(&opt.HideClean, "hide-clean", false, "")
+	flag.IntVar(&opt.MinReclaimPerc, "min-reclaim-perc", 0, "")
 	flag.BoolVar(&opt.DuplicatesOnlyFlag, "d", false, "")
 	flag.BoolVar(&opt.DuplicatesOnlyFlag, "duplicates", false, "")
+	flag.BoolVar(&opt.UnindexedOnly, "unindexed-only", false, "")
 	flag.Int64Var(&opt.MinFileBytes, "m", 0, "")
 	flag.Int64Var(&opt.MinFileBytes, "min-file-size", 0, "")
+	flag.StringVar(&opt.MaxCatalogAge, "max-catalog-age", "", "")
+	flag.BoolVar(&opt.SummaryByRoot, "summary-by-root", false, "")
+	flag.BoolVar(&opt.ReportHeader, "report-header", false, "")
+	flag.StringVar(&opt.MaxRuntime, "max-runtime", "", "")
+	flag.StringVar(&opt.Grep, "grep", "", "")
+	flag.StringVar(&opt.Format, "f", "", "")
+	flag.StringVar(&opt.Format, "format", "", "")
+	flag.StringVar(&opt.Format, "output-template", "", "")
+	flag.BoolVar(&opt.NullTerminated, "0", false, "")
+	flag.BoolVar(&opt.NullTerminated, "null-terminated", false, "")
+	flag.BoolVar(&opt.ZeroByteDuplicates, "zero-byte-duplicates", false, "")
+	flag.BoolVar(&opt.ShowHash, "show-hash", false, "")
+	flag.Var(&opt.Aliases, "alias", "")
+	flag.BoolVar(&opt.VerifyOnMatch, "hash-verify-on-match", false, "")
+	flag.IntVar(&opt.VerifyGroupsOver, "rehash-on-collision", 0, "")
+	flag.BoolVar(&opt.HardlinkSavings, "report-hardlink-savings", false, "")
+	flag.IntVar(&opt.ParallelFolders, "parallel-folders", 1, "")
+	flag.IntVar(&opt.StreamChunk, "stream-chunk", 0, "")
+	flag.IntVar(&opt.LimitPerFolder, "limit-per-folder", 0, "")
+	flag.StringVar(&opt.FilenameEncoding, "filename-encoding", utils.EncodingUTF8, "")
+	flag.StringVar(&opt.Fadvise, "fadvise", utils.FadviseNone, "")
+	flag.IntVar(&opt.MaxOpenFiles, "max-open-files", 0, "")
+	flag.BoolVar(&opt.SizeHistogram, "size-histogram", false, "")
 }
 
 func main() {
 
 	flag.Parse()
+	if err := utils.SetupLogger(opt.LogLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if err := startProfiles(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	defer stopProfiles()
+
+	if opt.ExcludeOSJunk {
+		opt.Excludes = append(opt.Excludes, utils.OSJunkNames...)
+	}
+	var maxCatalogAge time.Duration //checked by the listing, validated before any database is loaded
+	if opt.MaxCatalogAge != "" {
+		var err error
+		if maxCatalogAge, err = utils.ParseAge(opt.MaxCatalogAge); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max-catalog-age %v\n", err)
+			exit(1)
+		}
+	}
 
 	switch { // No expression here, defaults to 'switch true'
 	case opt.Overall:
@@ -98,14 +412,212 @@ func main() {
 		opt.OutputType = 0
 	}
 
+	if opt.PrintConfig {
+		printConfig()
+		return
+	}
+
+	if opt.CheckDB {
+		checkDatabase()
+		return
+	}
+	if opt.TrimDBTo != "" {
+		trimDatabase(opt.TrimDBTo)
+		return
+	}
+	if opt.OrphanFolders || opt.RemoveOrphanFolders {
+		orphanFolders(opt.RemoveOrphanFolders)
+		return
+	}
+	if opt.MoveFolder != "" {
+		moveFolder(opt.MoveFolder)
+		return
+	}
+	if opt.SizeCollisions > 0 {
+		workflow.SizeCollisionsReport(loadDatabase(), opt.SizeCollisions)
+		return
+	}
+
+	if opt.FilesOfSize != -1 {
+		if opt.FilesOfSize < 0 {
+			fmt.Fprintf(os.Stderr, "Error: --files-of-size must be a size in bytes, 0 or greater\n")
+			exit(1)
+		}
+		workflow.FilesOfSizeReport(loadDatabase(), opt.FilesOfSize)
+		return
+	}
+	if opt.DirPairs != 0 {
+		if opt.DirPairs < 0 {
+			fmt.Fprintf(os.Stderr, "Error: --dir-pairs must be a positive number of pairs\n")
+			exit(1)
+		}
+		workflow.DirPairsReport(loadDatabase(), opt.DirPairs)
+		return
+	}
+	if opt.DuplicateDirs {
+		workflow.DuplicateDirsReport(loadDatabase())
+		return
+	}
+	if opt.Containing != "" {
+		roots, err := utils.AbsPaths(opt.Containing)
+		if err != nil || len(roots) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --containing folders %q\n", opt.Containing)
+			exit(1)
+		}
+		workflow.ContainingReport(loadDatabase(), roots)
+		return
+	}
+	if opt.CaseCollisions {
+		workflow.CaseCollisionsReport(loadDatabase())
+		return
+	}
+	if opt.DBStats {
+		workflow.DatabaseStatsReport(loadDatabase())
+		return
+	}
+	if opt.CheckStdin {
+		checkStdin()
+		return
+	}
+	if opt.CompareHashModes > 0 {
+		filesHashMap := loadDatabase()
+		metadata, err := config.LoadMetadata()
+		if err != nil {
+			slog.Warn("cannot read the database metadata, assuming the default tiny multiplier", "err", err)
+		}
+		workflow.CompareHashModesReport(filesHashMap, opt.CompareHashModes, metadata)
+		return
+	}
+
 	paths := flag.Args() // Collect all non-flag arguments as paths
 
+	if opt.SizeTolerance != 0 {
+		similarFiles(opt.SizeTolerance, paths)
+		return
+	}
+	if opt.HTMLReport != "" {
+		writeHTMLReport(opt.HTMLReport, paths)
+		return
+	}
+	if opt.DetectTruncated {
+		roots, err := utils.AbsPathList(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		workflow.TruncatedFilesReport(loadDatabase(), roots)
+		return
+	}
+	if opt.DedupeSimulation {
+		roots, err := utils.AbsPathList(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		workflow.DedupeSimulationReport(loadDatabase(), roots, opt.KeepUnder)
+		return
+	}
+	if opt.Verify {
+		verifyDatabase(paths)
+		return
+	}
+	if opt.Largest != 0 {
+		if opt.Largest < 0 {
+			fmt.Fprintf(os.Stderr, "Error: --largest must be a positive number of files\n")
+			exit(1)
+		}
+		roots, err := utils.AbsPathList(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		workflow.LargestFilesReport(loadDatabase(), roots, opt.Largest)
+		return
+	}
+	if opt.FlatGroups {
+		roots, err := utils.AbsPathList(paths)
+		if err == nil {
+			_, err = workflow.WriteFlatGroups(os.Stdout, loadDatabase(), roots, opt.GroupSeparator, opt.GroupMarker)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+	if opt.PrintDuplicatesNull {
+		printDuplicatesNull(paths)
+		return
+	}
+	if opt.TSV {
+		roots, err := utils.AbsPathList(paths)
+		if err == nil {
+			_, err = workflow.WriteTSV(os.Stdout, loadDatabase(), roots)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+	if opt.RmlintJSON {
+		roots, err := utils.AbsPathList(paths)
+		metadata, metaErr := config.LoadMetadata()
+		if metaErr != nil || !metadata.ContentHashed() {
+			slog.Warn("the database does not hash the whole content of the files (not -U), the groups may hold" +
+				" different files: compare them before removing any lint")
+		}
+		if err == nil {
+			_, err = workflow.WriteRmlintJSON(os.Stdout, loadDatabase(), roots, opt.KeepUnder, os.Args,
+				metadata.ContentHashed())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+	if opt.GenScript != "" {
+		genScript(opt.GenScript, paths)
+		return
+	}
+
+	if opt.ResumeAction != "" {
+		resumeAction(opt.ResumeAction, paths)
+		return
+	}
+	if opt.KeepNewest {
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --keep-newest requires the paths to clean up\n")
+			exit(1)
+		}
+		keepNewest(paths)
+		return
+	}
+
+	if opt.DiffDB {
+		if len(paths) != 2 {
+			fmt.Fprintf(os.Stderr, "Error: --diff-db requires exactly two database files\n")
+			exit(1)
+		}
+		diffDatabases(paths[0], paths[1])
+		return
+	}
+	if opt.DiffFlag {
+		if len(paths) != 2 {
+			fmt.Fprintf(os.Stderr, "Error: --diff requires exactly two files\n")
+			exit(1)
+		}
+		diffFiles(paths[0], paths[1])
+		return
+	}
+
 	if len(paths) == 0 { // Ensure at least one path is provided
 		if opt.UpdateFlag || opt.UpdateFullFlag { //manage the -u case that is permessive
 			userPath, uerr := utils.UserPathInfo()
 			if uerr != nil {
 				fmt.Printf(uerr.Error())
-				os.Exit(1)
+				exit(1)
 			}
 			paths = append(paths, userPath)
 		} else {
@@ -118,27 +630,97 @@ func main() {
 	for _, path := range paths {
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			fmt.Fprintf(os.Stderr, "Error: path '%s' does not exist\n", path)
-			os.Exit(1)
+			exit(1)
+		}
+	}
+	//overlapping paths would walk the same files twice, without recursion a
+	//folder lists only its own files and nested paths do not overlap
+	if opt.RecurseFlag || opt.UpdateFlag || opt.UpdateFullFlag || opt.EstimateOnly {
+		var pruned []string
+		var err error
+		if paths, pruned, err = utils.PruneNestedPaths(paths); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		for _, path := range pruned {
+			slog.Warn("ignoring path, it is under another provided path", "path", path)
 		}
 	}
 
 	var filesHashMap = make(map[utils.HashPair][]string)
 
-	if opt.UpdateFlag || opt.UpdateFullFlag {
+	if opt.EstimateOnly {
+		opt.RecurseFlag = true
+		estimate, err := workflow.EstimateDuplicates(paths, opt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		utils.PrintSeparator(workflow.SEP_WIDTH)
+		fmt.Println("DUPLICATES ESTIMATE from the filesizes, no file is read")
+		fmt.Print(estimate.StringSummary())
+		utils.PrintSeparator(workflow.SEP_WIDTH)
+	} else if (opt.UpdateFlag || opt.UpdateFullFlag) && opt.DryRun {
+		opt.RecurseFlag = true // -u implies -r
+		var err error
+		filesHashMap, err = config.LoadMap()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			exit(1)
+		}
+		plan, err := workflow.DryRunUpdate(paths, opt, config.InvertMap(filesHashMap))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		utils.PrintSeparator(workflow.SEP_WIDTH)
+		fmt.Println("DRY RUN, the files database is not modified")
+		fmt.Print(plan.StringSummary())
+		utils.PrintSeparator(workflow.SEP_WIDTH)
+	} else if opt.UpdateFlag || opt.UpdateFullFlag {
 		opt.RecurseFlag = true // -u implies -r
+		var resume *workflow.Resume
+		if opt.ResumeFlag {
+			resume = loadResume()
+		}
+		var checkpoint *config.Checkpoint
 		var err error
-		filesHashMap, err = workflow.CalculateFileHashes(
+		filesHashMap, checkpoint, err = workflow.CalculateFileHashes(
 			paths,
 			opt,
+			resume,
 		)
 
-		if err != nil {
+		scanErr := err
+		interrupted := errors.Is(scanErr, workflow.ErrScanInterrupted)
+		if err != nil && !interrupted {
 			fmt.Fprintf(os.Stderr, "\nError calculating hashes: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		if err = config.SaveMap(filesHashMap); err != nil {
 			fmt.Fprintf(os.Stderr, "\nError saving config: %v\n", err)
-			os.Exit(1)
+			exit(1)
+		}
+		if interrupted {
+			//what was hashed is saved anyway, but the run must not look successful
+			fmt.Println()
+			slog.Warn(scanErr.Error())
+			if err = config.SaveCheckpoint(*checkpoint); err != nil {
+				slog.Error("failed to save checkpoint", "err", err)
+			} else {
+				slog.Info("Run again with the same paths and --resume-from-checkpoint to continue.")
+			}
+			fmt.Printf("Number of different files in database: %d\n", len(filesHashMap))
+			exit(1)
+		}
+		if err = config.RemoveCheckpoint(); err != nil {
+			slog.Error("failed to remove checkpoint", "err", err)
+		}
+		roots, _ := utils.AbsPathList(paths)
+		if err = config.SaveMetadata(config.Metadata{LastUpdate: time.Now(), FullHash: opt.UpdateFullFlag,
+			SparseAware: opt.SparseAware, MaxHashBytes: opt.MaxHashBytes, IncludeXattrs: opt.IncludeXattrs,
+			TinyMultiplier: opt.TinyMultiplier, FullHashAbove: opt.FullHashAbove, Roots: roots}); err != nil {
+			slog.Error("failed to save metadata", "err", err)
 		}
 		fmt.Println("\nFiles database updated successfully")
 		fmt.Printf("Number of different files in database: %d\n", len(filesHashMap))
@@ -147,7 +729,7 @@ func main() {
 		filesHashMap, err = config.LoadMap()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		//COMMENTED OUT AN OLD STUDY OF MINE ABOUT UNIQUESS OF FILES
@@ -166,7 +748,14 @@ func main() {
 		// 	utils.RepresentBytes(overallSIZE))
 		// os.Exit(1)
 
-		fmt.Printf("File database loaded, Number of different files in database: %d\n", len(filesHashMap))
+		if opt.ReportHeader {
+			printReportHeader(paths)
+		}
+		//machine readable outputs keep stdout clean
+		utils.FprintfIf(!opt.JSONAll && !opt.NullTerminated, os.Stdout, "File database loaded, Number of different files in database: %d\n", len(filesHashMap))
+		if opt.MaxCatalogAge != "" {
+			checkCatalogAge(maxCatalogAge)
+		}
 		reversefilesHashMap := config.InvertMap(filesHashMap)
 		if err = workflow.ListFiles(
 			paths,
@@ -175,7 +764,450 @@ func main() {
 			reversefilesHashMap,
 		); err != nil {
 			fmt.Fprintf(os.Stderr, "Error listing files: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 	}
 }
+
+// printConfig prints the options resolved from the command line as JSON, with
+// the schema version of the JSON outputs.
+func printConfig() {
+	resolved := opt
+	if resolved.NumThreads == 0 {
+		resolved.NumThreads = runtime.NumCPU()
+	}
+	if resolved.UpdateFlag || resolved.UpdateFullFlag || resolved.EstimateOnly {
+		resolved.RecurseFlag = true //-u and -U imply -r
+	}
+	resolved.ColorScheme = workflow.ResolveColorScheme(resolved.ColorScheme)
+	out, err := json.MarshalIndent(struct {
+		Version int         `json:"version"`
+		Options cfg.Options `json:"options"`
+	}{workflow.JSONSchemaVersion, resolved}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	fmt.Println(string(out))
+}
+
+// checkDatabase reports the problems of the database, exits 1 when there are any.
+func checkDatabase() {
+	filesHashMap := loadDatabase() //exits when it cannot be decoded
+	problems := config.CheckMap(filesHashMap)
+	if _, err := config.LoadMetadata(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	checkpoint, err := config.LoadCheckpoint()
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if checkpoint != nil {
+		slog.Warn("the last update was interrupted, the database is partial", "root", checkpoint.Root)
+	}
+	const maxShown = 20
+	for i, problem := range problems {
+		if i == maxShown {
+			fmt.Printf("... and %d more problems\n", len(problems)-maxShown)
+			break
+		}
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("Database check failed, %d problems found\n", len(problems))
+		exit(1)
+	}
+	fmt.Printf("Database check passed, number of different files in database: %d\n", len(filesHashMap))
+}
+
+// checkStdin lists the catalogued files with the same content as stdin, exits
+// 1 when there is none.
+func checkStdin() {
+	filesHashMap := loadDatabase()
+	metadata, err := config.LoadMetadata()
+	if err != nil {
+		slog.Warn("cannot read the database metadata, assuming the quick hash", "err", err)
+	}
+	size, matches, err := workflow.StreamMatches(os.Stdin, filesHashMap, metadata)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+		exit(1)
+	}
+	if len(matches) == 0 {
+		fmt.Printf("No file in database with the same content (%s)\n", utils.RepresentBytes(size))
+		exit(1)
+	}
+	for _, path := range matches {
+		fmt.Println(utils.EscapeControl(path))
+	}
+}
+
+// orphanFolders reports the database folders no longer on disk and, when
+// remove is set, removes their files from the database.
+func orphanFolders(remove bool) {
+	filesHashMap := loadDatabase()
+	orphans, unmounted := config.OrphanFolders(filesHashMap)
+	for _, dir := range orphans {
+		fmt.Println(utils.EscapeControl(dir))
+	}
+	fmt.Printf("%d folders of the database no longer exist\n", len(orphans))
+	for _, dir := range unmounted {
+		fmt.Printf("kept %s, it is under an empty folder (unmounted disk?)\n", utils.EscapeControl(dir))
+	}
+	if !remove || len(orphans) == 0 {
+		return
+	}
+	if !opt.Yes && !confirm(fmt.Sprintf("Remove the files of %d folders from the database?", len(orphans))) {
+		fmt.Println("Nothing removed")
+		return
+	}
+	removed := config.RemoveUnder(filesHashMap, orphans)
+	if err := config.SaveMap(filesHashMap); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Removed %d files of the missing folders\n", removed)
+}
+
+// trimDatabase keeps in the database only the files under the provided roots.
+func trimDatabase(rootList string) {
+	roots, err := utils.AbsPaths(rootList)
+	if err != nil || len(roots) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid --trim-db-to roots '%s' %v\n", rootList, err)
+		exit(1)
+	}
+	filesHashMap := loadDatabase()
+	removed := config.TrimMap(filesHashMap, roots)
+	if err = config.SaveMap(filesHashMap); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Removed %d files outside the provided roots\n", removed)
+	fmt.Printf("Number of different files in database: %d\n", len(filesHashMap))
+}
+
+// genScript writes the cleanup script for the duplicate groups under paths.
+func genScript(scriptPath string, paths []string) {
+	roots, err := utils.AbsPathList(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	filesHashMap := loadDatabase()
+	if metadata, metaErr := config.LoadMetadata(); metaErr != nil || !metadata.ContentHashed() {
+		slog.Warn("the database does not hash the whole content of the files (not -U), the groups may hold" +
+			" different files: compare them before uncommenting any line")
+	}
+	script, err := os.OpenFile(scriptPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating script: %v\n", err)
+		exit(1)
+	}
+	numGroups, err := workflow.WriteCleanupScript(script, filesHashMap, roots, opt.KeepUnder)
+	if closeErr := script.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing script: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Cleanup script %s written, %d duplicate groups (all lines commented out)\n", scriptPath, numGroups)
+}
+
+// printDuplicatesNull prints the removable copies under paths NUL terminated,
+// exits 1 when the database does not hash the whole content of the files: the
+// output feeds rm and a quick hash match is not a duplicate.
+func printDuplicatesNull(paths []string) {
+	roots, err := utils.AbsPathList(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	metadata, err := config.LoadMetadata()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	if !metadata.ContentHashed() {
+		fmt.Fprintf(os.Stderr, "Error: --print-duplicates-null requires a database built with -U (full hash,"+
+			" no --max-hash-bytes), run an update with -U first\n")
+		exit(1)
+	}
+	if _, err = workflow.WriteRedundantPaths(os.Stdout, loadDatabase(), roots, opt.KeepUnder); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+}
+
+// similarFiles prints the fuzzy report of the similar files under paths.
+func similarFiles(tolerance float64, paths []string) {
+	if tolerance < 0 || tolerance > 100 {
+		fmt.Fprintf(os.Stderr, "Error: --size-tolerance must be a percentage between 0 and 100\n")
+		exit(1)
+	}
+	roots, err := utils.AbsPathList(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	workflow.SimilarFilesReport(loadDatabase(), roots, tolerance)
+}
+
+// verifyDatabase reports the catalogued files under paths changed since the
+// last update, exits 1 with --fail-on-changed when there are any.
+func verifyDatabase(paths []string) {
+	roots, err := utils.AbsPathList(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	filesHashMap := loadDatabase()
+	metadata, err := config.LoadMetadata()
+	if err != nil {
+		slog.Warn("cannot read the database metadata, assuming the quick hash", "err", err)
+	}
+	if numChanged := workflow.VerifyReport(filesHashMap, roots, metadata); numChanged > 0 && opt.FailOnChanged {
+		exit(1)
+	}
+}
+
+// writeHTMLReport writes the HTML duplicates report for the groups under paths.
+func writeHTMLReport(reportPath string, paths []string) {
+	roots, err := utils.AbsPathList(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	filesHashMap := loadDatabase()
+	report, err := os.Create(reportPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating report: %v\n", err)
+		exit(1)
+	}
+	numGroups, err := workflow.WriteHTMLReport(report, filesHashMap, roots)
+	if closeErr := report.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("HTML report %s written, %d duplicate groups\n", reportPath, numGroups)
+}
+
+// keepNewest deletes the older copies of the duplicates under paths, after confirmation.
+func keepNewest(paths []string) {
+	roots, err := utils.AbsPathList(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	filesHashMap := loadDatabase()
+	plan := workflow.PlanKeepNewest(filesHashMap, roots, opt.KeepUnder)
+	fmt.Print(plan.StringSummary())
+	if len(plan.Deletions) == 0 {
+		return
+	}
+	if !opt.Yes && !confirm(fmt.Sprintf("Delete %d files (%s)?", len(plan.Deletions), utils.RepresentBytes(plan.Size))) {
+		fmt.Println("Nothing deleted")
+		return
+	}
+	var actionLog *workflow.ActionLog
+	if opt.ActionLog != "" {
+		if actionLog, err = workflow.CreateActionLog(opt.ActionLog, plan); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			exit(1)
+		}
+		defer actionLog.Close()
+	}
+	executeDeletions(plan, filesHashMap, roots, actionLog)
+}
+
+// resumeAction continues the deletions of an interrupted --keep-newest recorded
+// in the action log at logPath, paths are the roots for --remove-empty-dirs.
+func resumeAction(logPath string, paths []string) {
+	roots, err := utils.AbsPathList(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	plan, done, err := workflow.LoadActionLog(logPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	filesHashMap := loadDatabase()
+	remaining := plan.Remaining(done, filesHashMap)
+	fmt.Printf("Action log %s: %d deletions planned, %d already done\n",
+		logPath, len(plan.Deletions), len(plan.Deletions)-len(remaining.Deletions))
+	fmt.Print(remaining.StringSummary())
+	if len(remaining.Deletions) > 0 &&
+		!opt.Yes && !confirm(fmt.Sprintf("Delete %d files (%s)?", len(remaining.Deletions), utils.RepresentBytes(remaining.Size))) {
+		fmt.Println("Nothing deleted")
+		return
+	}
+	actionLog, err := workflow.OpenActionLog(logPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	defer actionLog.Close()
+	if opt.RemoveEmptyDirs && len(roots) == 0 {
+		slog.Warn("--remove-empty-dirs needs the paths of the cleanup, no folder is removed")
+	}
+	executeDeletions(remaining, filesHashMap, roots, actionLog)
+}
+
+// executeDeletions deletes the planned files, saves the database and reports,
+// exits 1 when some files could not be deleted.
+func executeDeletions(plan workflow.DeletePlan, filesHashMap map[utils.HashPair][]string, roots []string,
+	actionLog *workflow.ActionLog) {
+	deleted, freed := plan.Execute(filesHashMap, actionLog)
+	if err := config.SaveMap(filesHashMap); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Deleted %d files, freed %s\n", len(deleted), utils.RepresentBytes(freed))
+	if opt.RemoveEmptyDirs {
+		fmt.Printf("Removed %d empty folders\n", workflow.RemoveEmptyDirs(deleted, roots))
+	}
+	if len(deleted) < len(plan.Deletions) {
+		exit(1)
+	}
+}
+
+// confirm asks a yes/no question on the terminal, anything but y/yes is a no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// moveFolder renames in the database the paths of a folder moved to a new place.
+func moveFolder(move string) {
+	sep := strings.Index(move, "=") //the new path may contain "=" too
+	if sep <= 0 || sep == len(move)-1 {
+		fmt.Fprintf(os.Stderr, "Error: --move-folder must be in the form /old/path=/new/path, got '%s'\n", move)
+		exit(1)
+	}
+	oldRoot, err := filepath.Abs(move[:sep])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --move-folder path '%s' %v\n", move[:sep], err)
+		exit(1)
+	}
+	newRoot, err := filepath.Abs(move[sep+1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --move-folder path '%s' %v\n", move[sep+1:], err)
+		exit(1)
+	}
+	if _, err = os.Stat(newRoot); err != nil {
+		slog.Warn("the new folder is not accessible, renaming anyway", "path", newRoot, "err", err)
+	}
+	filesHashMap := loadDatabase()
+	renamed := config.RenameFolder(filesHashMap, oldRoot, newRoot)
+	if err = config.SaveMap(filesHashMap); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving config: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Renamed %d files from %s to %s\n", renamed, oldRoot, newRoot)
+}
+
+// diffDatabases reports the differences between two saved files databases.
+func diffDatabases(oldPath string, newPath string) {
+	oldMap, err := config.LoadMapFile(oldPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	newMap, err := config.LoadMapFile(newPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(1)
+	}
+	workflow.DiffDatabasesReport(oldMap, newMap)
+}
+
+// diffFiles reports the first differing byte of two files, exits 1 when they differ.
+func diffFiles(pathA string, pathB string) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(2)
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exit(2)
+	}
+	defer fileB.Close()
+
+	offset, equal, err := utils.FirstDiffOffset(fileA, fileB)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error comparing files: %v\n", err)
+		exit(2)
+	}
+	if equal {
+		fmt.Printf("Files are identical (%s)\n", utils.RepresentBytes(offset))
+		return
+	}
+	fmt.Printf("Files differ, first difference at byte offset %d\n", offset)
+	exit(1)
+}
+
+// loadResume reads the checkpoint and the partial database of an interrupted update.
+func loadResume() *workflow.Resume {
+	checkpoint, err := config.LoadCheckpoint()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading checkpoint: %v\n", err)
+		exit(1)
+	}
+	if checkpoint == nil {
+		fmt.Fprintf(os.Stderr, "No checkpoint found, the last update was not interrupted\n")
+		exit(1)
+	}
+	filesHashMap := loadDatabase()
+	slog.Info("Resuming after checkpoint", "root", checkpoint.Root, "folder", checkpoint.Folder)
+	return &workflow.Resume{Checkpoint: *checkpoint, HashMap: filesHashMap}
+}
+
+// checkCatalogAge warns when the last complete update is older than maxAge.
+func checkCatalogAge(maxAge time.Duration) {
+	metadata, err := config.LoadMetadata()
+	if err != nil {
+		slog.Warn("failed to load metadata", "err", err)
+		return
+	}
+	if metadata == nil {
+		slog.Warn("the database has no update time, run an update (-u/-U) to record it")
+		return
+	}
+	if elapsed := time.Since(metadata.LastUpdate); elapsed > maxAge {
+		slog.Warn("the database is older than --max-catalog-age, run an update (-u/-U) before trusting the report",
+			"last_update", metadata.LastUpdate.Format(time.DateTime), "age", elapsed.Round(time.Minute))
+	}
+}
+
+// printReportHeader prints the provenance of the listing of paths.
+func printReportHeader(paths []string) {
+	if opt.NullTerminated {
+		slog.Warn("--report-header is not printed with --null-terminated output")
+		return
+	}
+	metadata, err := config.LoadMetadata()
+	if err != nil {
+		slog.Warn("failed to load metadata", "err", err)
+	}
+	roots, _ := utils.AbsPathList(paths)
+	workflow.NewReportHeader(roots, os.Args[1:], metadata).Write(os.Stdout, opt.JSONAll)
+}
+
+// loadDatabase loads the files database for the catalog-only commands, exits on error.
+func loadDatabase() map[utils.HashPair][]string {
+	filesHashMap, err := config.LoadMap()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		exit(1)
+	}
+	return filesHashMap
+}
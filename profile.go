@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+var cpuProfile *os.File // open while the CPU profile is running
+
+// startProfiles starts the CPU profile of --cpuprofile, if requested.
+func startProfiles() error {
+	if opt.CPUProfile == "" {
+		return nil
+	}
+	file, err := os.Create(opt.CPUProfile)
+	if err != nil {
+		return fmt.Errorf("failed to create CPU profile: %w", err)
+	}
+	if err := pprof.StartCPUProfile(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to start CPU profile: %w", err)
+	}
+	cpuProfile = file
+	return nil
+}
+
+// stopProfiles stops the CPU profile and writes the heap profile of
+// --memprofile, if requested. It is called once, on any exit path.
+func stopProfiles() {
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		cpuProfile.Close()
+		cpuProfile = nil
+	}
+	if opt.MemProfile != "" {
+		file, err := os.Create(opt.MemProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to create memory profile: %v\n", err)
+			return
+		}
+		defer file.Close()
+		runtime.GC() // up-to-date statistics of the live objects
+		if err := pprof.WriteHeapProfile(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to write memory profile: %v\n", err)
+		}
+		opt.MemProfile = ""
+	}
+}
+
+// exit stops the profiles, which os.Exit would skip, and exits with code.
+func exit(code int) {
+	stopProfiles()
+	os.Exit(code)
+}
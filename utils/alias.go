@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PathAlias maps a real root path to the friendly name displayed in its place.
+type PathAlias struct {
+	Root string
+	Name string
+}
+
+// PathAliases is a repeatable --alias /real/root=NAME flag, it only changes how
+// paths are displayed, the database keeps the real paths.
+type PathAliases []PathAlias
+
+func (a *PathAliases) String() string {
+	items := make([]string, len(*a))
+	for i, alias := range *a {
+		items[i] = alias.Root + "=" + alias.Name
+	}
+	return strings.Join(items, ",")
+}
+
+// Set parses and adds a /real/root=NAME alias, the root is made absolute.
+func (a *PathAliases) Set(value string) error {
+	sep := strings.LastIndex(value, "=")
+	if sep <= 0 || sep == len(value)-1 {
+		return fmt.Errorf("alias must be in the form /real/root=NAME, got %q", value)
+	}
+	root, err := filepath.Abs(value[:sep])
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for %s: %v", value[:sep], err)
+	}
+	*a = append(*a, PathAlias{Root: root, Name: value[sep+1:]})
+	return nil
+}
+
+// Display returns path with its root replaced by the alias name; when several
+// aliases match, the longest root wins. Paths without an alias are unchanged.
+func (a PathAliases) Display(path string) string {
+	best := -1
+	for i, alias := range a {
+		if IsUnder(path, alias.Root) && (best < 0 || len(alias.Root) > len(a[best].Root)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return path
+	}
+	rest := strings.TrimPrefix(path, a[best].Root)
+	if rest != "" && !strings.HasPrefix(rest, string(filepath.Separator)) {
+		rest = string(filepath.Separator) + rest //the root is the filesystem root
+	}
+	return a[best].Name + rest
+}
+
+// DisplayAll applies Display to each path.
+func (a PathAliases) DisplayAll(paths []string) []string {
+	if len(a) == 0 {
+		return paths
+	}
+	shown := make([]string, len(paths))
+	for i, path := range paths {
+		shown[i] = a.Display(path)
+	}
+	return shown
+}
+
+// PathList is a repeatable flag of paths, e.g. --keep-under, each path is made
+// absolute.
+type PathList []string
+
+func (l *PathList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds a path, made absolute.
+func (l *PathList) Set(value string) error {
+	path, err := filepath.Abs(value)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for %s: %v", value, err)
+	}
+	*l = append(*l, path)
+	return nil
+}
+
+// OSJunkNames are the names excluded by --exclude-os-junk: thumbnails caches,
+// folder settings and metadata folders that operating systems create
+// everywhere, identical copies nobody wants reported.
+var OSJunkNames = []string{
+	"Thumbs.db", "ehthumbs.db", "desktop.ini", "$RECYCLE.BIN", "System Volume Information",
+	".DS_Store", "._*", "__MACOSX", ".Spotlight-V100", ".Trashes", ".fseventsd",
+	".directory",
+}
+
+// NamePatterns is a repeatable --exclude flag of shell patterns (e.g. *.tmp)
+// matched against the file and folder names.
+type NamePatterns []string
+
+func (p *NamePatterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set adds a pattern, checking its syntax.
+func (p *NamePatterns) Set(value string) error {
+	if _, err := filepath.Match(value, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", value, err)
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// Match tells if name matches one of the patterns.
+func (p NamePatterns) Match(name string) bool {
+	for _, pattern := range p {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferPool holds the read buffers shared by the hashing workers, so that
+// hashing millions of small files does not allocate a buffer per file.
+var copyBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// copyPooled is io.Copy with a pooled buffer. src is wrapped so that its
+// WriterTo (os.File has one) does not bypass the buffer with its own.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	return io.CopyBuffer(dst, struct{ io.Reader }{src}, *buf)
+}
+
+// copyNPooled is io.CopyN with a pooled buffer, it returns io.EOF when src
+// has less than n bytes.
+func copyNPooled(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	written, err := copyPooled(dst, io.LimitReader(src, n))
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		err = io.EOF
+	}
+	return written, err
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Filename encodings of --filename-encoding
+const (
+	EncodingUTF8   = "utf8"
+	EncodingLatin1 = "latin1" // ISO-8859-1, each byte is a character
+)
+
+// CheckEncoding validates a --filename-encoding value.
+func CheckEncoding(encoding string) error {
+	switch encoding {
+	case EncodingUTF8, EncodingLatin1:
+		return nil
+	}
+	return fmt.Errorf("unknown filename encoding %q (utf8, latin1)", encoding)
+}
+
+// DecodeName returns the name s for display. Names that are valid UTF-8 are
+// returned unchanged, the others are decoded from the provided encoding. With
+// utf8 the invalid bytes are kept, EscapeControl shows them as \xNN.
+func DecodeName(s string, encoding string) string {
+	if encoding != EncodingLatin1 || utf8.ValidString(s) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		sb.WriteRune(rune(s[i]))
+	}
+	return sb.String()
+}
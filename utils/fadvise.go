@@ -0,0 +1,19 @@
+package utils
+
+import "fmt"
+
+// Page cache advice modes of --fadvise
+const (
+	FadviseNone       = "none"
+	FadviseSequential = "sequential" // larger read-ahead
+	FadviseDontNeed   = "dontneed"   // sequential, and the pages read are dropped from the cache
+)
+
+// CheckFadvise validates a --fadvise mode.
+func CheckFadvise(mode string) error {
+	switch mode {
+	case FadviseNone, FadviseSequential, FadviseDontNeed:
+		return nil
+	}
+	return fmt.Errorf("unknown fadvise mode %q (none, sequential, dontneed)", mode)
+}
@@ -0,0 +1,41 @@
+//go:build linux && (amd64 || arm64 || riscv64 || ppc64le || loong64)
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// posix_fadvise advice values, as in <fcntl.h>
+const (
+	posixFadvSequential = 2
+	posixFadvDontNeed   = 4
+)
+
+func fadvise(file *os.File, advice int) error {
+	//offset 0 and length 0 mean the whole file
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, file.Fd(), 0, 0, uintptr(advice), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// AdviseBeforeRead tells the kernel that file is about to be read sequentially,
+// unless mode is FadviseNone.
+func AdviseBeforeRead(file *os.File, mode string) error {
+	if mode == FadviseNone {
+		return nil
+	}
+	return fadvise(file, posixFadvSequential)
+}
+
+// AdviseAfterRead drops the pages of file from the cache with FadviseDontNeed,
+// so that a scan does not evict the working set.
+func AdviseAfterRead(file *os.File, mode string) error {
+	if mode != FadviseDontNeed {
+		return nil
+	}
+	return fadvise(file, posixFadvDontNeed)
+}
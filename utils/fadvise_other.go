@@ -0,0 +1,15 @@
+//go:build !(linux && (amd64 || arm64 || riscv64 || ppc64le || loong64))
+
+package utils
+
+import "os"
+
+// AdviseBeforeRead does nothing, posix_fadvise is not used on this platform.
+func AdviseBeforeRead(file *os.File, mode string) error {
+	return nil
+}
+
+// AdviseAfterRead does nothing, posix_fadvise is not used on this platform.
+func AdviseAfterRead(file *os.File, mode string) error {
+	return nil
+}
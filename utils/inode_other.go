@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package utils
+
+import "io/fs"
+
+// FileIdentity is not supported on this platform, ok is always false.
+func FileIdentity(info fs.FileInfo) (id FileID, nlink uint64, ok bool) {
+	return FileID{}, 0, false
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package utils
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// FileIdentity returns the device+inode pair and the hardlink count of a file,
+// ok is false when the FileInfo does not carry the system stat data.
+func FileIdentity(info fs.FileInfo) (id FileID, nlink uint64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, 0, false
+	}
+	return FileID{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, uint64(st.Nlink), true
+}
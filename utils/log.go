@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// SetupLogger installs the default slog logger, writing key=value records to
+// stderr. level is one of debug, info, warn, error.
+func SetupLogger(level string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level '%s' (use debug, info, warn or error)", level)
+	}
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{} //the time clutters an interactive output
+			}
+			return attr
+		},
+	})
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
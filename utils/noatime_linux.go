@@ -0,0 +1,20 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// OpenNoAtime opens path for reading without updating its access time
+// (O_NOATIME). Only the owner of the file (or root) can do it, for the other
+// files it falls back to a plain open.
+func OpenNoAtime(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOATIME, 0)
+	if errors.Is(err, syscall.EPERM) {
+		return os.Open(path)
+	}
+	return file, err
+}
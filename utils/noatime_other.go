@@ -0,0 +1,11 @@
+//go:build !linux
+
+package utils
+
+import "os"
+
+// OpenNoAtime opens path for reading, O_NOATIME is not available on this
+// platform so the access time can be updated.
+func OpenNoAtime(path string) (*os.File, error) {
+	return os.Open(path)
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+)
+
+// sparseBlockSize is the granularity used to skip holes and zero blocks
+const sparseBlockSize int64 = 64 * 1024
+
+// SparseHashGen hashes only the allocated extents of file (found with
+// SEEK_DATA/SEEK_HOLE where supported). Aligned blocks made only of zeros are
+// skipped too, and each hashed block is prefixed by its offset, so the result
+// depends on the logical content and not on how holes are laid out on disk.
+// Please note the result is not comparable with HashGen on the same file.
+// please provide the hash obj instance unique per worker
+func SparseHashGen(hashEngine hash.Hash, file *os.File, fileSize int64) (string, error) {
+	if file == nil {
+		return "", fmt.Errorf("nil reader")
+	}
+	hashEngine.Reset()
+
+	buf := make([]byte, sparseBlockSize)
+	var offsetBytes [8]byte
+	nextBlock := int64(0) //first block not hashed yet, extents may share a block
+	for offset := int64(0); offset < fileSize; {
+		start, end, err := nextDataExtent(file, offset, fileSize)
+		if err != nil {
+			return "", fmt.Errorf("failed to find data extents: %w", err)
+		}
+		if start >= fileSize {
+			break
+		}
+		block := (start / sparseBlockSize) * sparseBlockSize
+		if block < nextBlock {
+			block = nextBlock
+		}
+		for ; block < end; block += sparseBlockSize {
+			n := Min64(sparseBlockSize, fileSize-block)
+			if _, err := file.ReadAt(buf[:n], block); err != nil {
+				return "", fmt.Errorf("failed to hash: %w", err)
+			}
+			if isZeroBlock(buf[:n]) {
+				continue
+			}
+			binary.BigEndian.PutUint64(offsetBytes[:], uint64(block))
+			hashEngine.Write(offsetBytes[:])
+			hashEngine.Write(buf[:n])
+		}
+		nextBlock = block
+		offset = end
+	}
+
+	return hex.EncodeToString(hashEngine.Sum(nil)), nil
+}
+
+var zeroBlock = make([]byte, sparseBlockSize)
+
+func isZeroBlock(b []byte) bool {
+	return bytes.Equal(b, zeroBlock[:len(b)])
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// whence values of lseek(2) for sparse files, not exported by syscall
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// nextDataExtent returns the first allocated extent [start, end) at or after
+// offset. start is fileSize when only holes remain. Filesystems without
+// SEEK_DATA support report the rest of the file as a single extent.
+func nextDataExtent(file *os.File, offset int64, fileSize int64) (int64, int64, error) {
+	start, err := file.Seek(offset, seekData)
+	if errors.Is(err, syscall.ENXIO) {
+		return fileSize, fileSize, nil
+	}
+	if errors.Is(err, syscall.EINVAL) {
+		return offset, fileSize, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := file.Seek(start, seekHole)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, Min64(end, fileSize), nil
+}
@@ -1,16 +1,24 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"os/user"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // func Int64ToBytes(n int64) []byte {
@@ -47,14 +55,28 @@ func FprintfIf(condition bool, w io.Writer, format string, a ...interface{}) (n
 	return 0, nil // If condition is false, do nothing and return 0 bytes written, no error.
 }
 
+// IsTerminal tells if the standard output is a terminal (not a pipe or a file).
+func IsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
 // PrintSeparator prints a line of hyphens that matches the desired width.
 func PrintSeparator(len int) {
+	FprintSeparator(os.Stdout, len)
+}
+
+// FprintSeparator writes a line of hyphens that matches the desired width.
+func FprintSeparator(w io.Writer, len int) {
 
 	// Create a string of hyphens with the determined width
 	separator := strings.Repeat("-", len)
 
 	// Print the separator
-	fmt.Println(separator)
+	fmt.Fprintln(w, separator)
 }
 
 // TODO Convert to generics
@@ -73,6 +95,134 @@ func Max(a, b int) int {
 	return b
 }
 
+// TODO Convert to generics
+func Min64(a, b int64) int64 {
+	if a > b {
+		return b
+	}
+	return a
+}
+
+// EscapeControl returns s with control characters and invalid UTF-8 bytes
+// escaped (\n, \t, \x1b, ...), so that a filename cannot break or spoof a
+// line based output. Strings without them are returned unchanged.
+func EscapeControl(s string) string {
+	if utf8.ValidString(s) && !strings.ContainsFunc(s, unicode.IsControl) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&sb, "\\x%02x", s[i])
+		case r == '\n':
+			sb.WriteString("\\n")
+		case r == '\r':
+			sb.WriteString("\\r")
+		case r == '\t':
+			sb.WriteString("\\t")
+		case unicode.IsControl(r) && r < 0x100:
+			fmt.Fprintf(&sb, "\\x%02x", r)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&sb, "\\u%04x", r)
+		default:
+			sb.WriteRune(r)
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// SymlinkHashPrefix starts the hashes of the symbolic links catalogued by
+// --hash-symlinks
+const SymlinkHashPrefix = "symlink:"
+
+// SymlinkHash returns the hash of a symbolic link whose content is its target
+// path string, prefixed so that it never matches the hash of a regular file.
+func SymlinkHash(target string) string {
+	sum := md5.Sum([]byte(target))
+	return SymlinkHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsSymlinkHash tells if hash is the one of a symbolic link (--hash-symlinks),
+// its size is the length of the target path, not of a file content.
+func IsSymlinkHash(hash string) bool {
+	return strings.HasPrefix(hash, SymlinkHashPrefix)
+}
+
+// ParseMagics converts a comma separated list of hex prefixes (e.g. 89504e47)
+// into byte signatures, empty items are skipped.
+func ParseMagics(list string) ([][]byte, error) {
+	var magics [][]byte
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(item)), "0x")
+		if item == "" {
+			continue
+		}
+		magic, err := hex.DecodeString(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex prefix %q: %v", item, err)
+		}
+		magics = append(magics, magic)
+	}
+	return magics, nil
+}
+
+// HasMagic tells if the file at path starts with one of the signatures,
+// noAtime opens it without updating its access time (--noatime).
+func HasMagic(path string, magics [][]byte, noAtime bool) (bool, error) {
+	maxLen := 0
+	for _, magic := range magics {
+		maxLen = Max(maxLen, len(magic))
+	}
+	open := os.Open
+	if noAtime {
+		open = OpenNoAtime
+	}
+	file, err := open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	head := make([]byte, maxLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	for _, magic := range magics {
+		if bytes.HasPrefix(head[:n], magic) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ParseAge parses a duration like time.ParseDuration, with days allowed as
+// integer number followed by d (e.g. 7d). Negative durations are rejected.
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		return 0, fmt.Errorf("invalid negative duration %q", s)
+	}
+	return d, err
+}
+
+// TODO Convert to generics
+func Max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // please provide the hash obj instance unique per worker
 func HashGen(hashEngine hash.Hash, file io.Reader) (string, error) {
 	if file == nil {
@@ -81,21 +231,104 @@ func HashGen(hashEngine hash.Hash, file io.Reader) (string, error) {
 
 	hashEngine.Reset() // := md5.New()
 
-	if _, err := io.Copy(hashEngine, file); err != nil {
+	if _, err := copyPooled(hashEngine, file); err != nil {
 		return "", fmt.Errorf("failed to hash: %w", err)
 	}
 	hashSum := fmt.Sprintf("%x", hashEngine.Sum(nil))
 	return hashSum, nil
 }
 
+// HashGenN is HashGen reading at most the first maxBytes bytes of file, files
+// shorter than maxBytes are hashed whole.
+func HashGenN(hashEngine hash.Hash, file io.Reader, maxBytes int64) (string, error) {
+	if file == nil {
+		return "", fmt.Errorf("nil reader")
+	}
+
+	hashEngine.Reset()
+
+	if _, err := copyNPooled(hashEngine, file, maxBytes); err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to hash: %w", err)
+	}
+	hashSum := fmt.Sprintf("%x", hashEngine.Sum(nil))
+	return hashSum, nil
+}
+
+// PartialHashPrefix starts the hashes of --resume-hash, computed on the
+// readable prefix of a file that failed to be read
+const PartialHashPrefix = "partial:"
+
+// IsPartialHash tells if hash is a --resume-hash one: it covers only the
+// readable prefix of the file, it does not tell the content.
+func IsPartialHash(hash string) bool {
+	return strings.HasPrefix(hash, PartialHashPrefix)
+}
+
+// PartialHashGen hashes file from the beginning up to the first read error,
+// for files on failing media. The hash is PartialHashPrefix followed by the
+// number of bytes read and the md5 of them, files with the same size and the
+// same readable prefix share it.
+func PartialHashGen(hashEngine hash.Hash, file io.ReadSeeker) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to seek to the beginning: %w", err)
+	}
+	hashEngine.Reset()
+	readable, _ := copyPooled(hashEngine, file) //the error is expected, what was read is kept
+	return fmt.Sprintf("%s%d:%x", PartialHashPrefix, readable, hashEngine.Sum(nil)), nil
+}
+
+// FirstDiffOffset reads a and b until the first mismatching byte and returns
+// its offset. When one reader ends first, the offset is the shorter length.
+// equal is true only when both readers have the same content and length.
+func FirstDiffOffset(a io.Reader, b io.Reader) (offset int64, equal bool, err error) {
+	const chunk = 64 * 1024
+	bufA := make([]byte, chunk)
+	bufB := make([]byte, chunk)
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		nB, errB := io.ReadFull(b, bufB)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return offset, false, fmt.Errorf("failed to read: %w", errA)
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return offset, false, fmt.Errorf("failed to read: %w", errB)
+		}
+		n := Min(nA, nB)
+		for i := 0; i < n; i++ {
+			if bufA[i] != bufB[i] {
+				return offset + int64(i), false, nil
+			}
+		}
+		offset += int64(n)
+		if nA != nB {
+			return offset, false, nil
+		}
+		if nA < chunk { //both ended together
+			return offset, true, nil
+		}
+	}
+}
+
 type HashPair struct {
 	Filesize int64 //please note this come first, useful for equality check
 	Hash     string
 }
 
+// FileID identifies a file on disk, paths with the same FileID are hardlinks
+// to the same inode and do not use additional space.
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// DefaultTinyMultiplier is the default of --tiny-multiplier: files up to 10
+// areas are read fully by the quick hash
+const DefaultTinyMultiplier int64 = 10
+
 // please provide the hash obj instance unique per worker
-func QuickHashGen(hashEngine hash.Hash, file io.Reader, areasize int64, fileSize int64) (string, error) {
-	const BIG_MULTIPLIER int = 10
+// files up to tinyMultiplier*areasize bytes are read fully, seeks cost more
+// than reading them, bigger files are sampled at the head and at the tail
+func QuickHashGen(hashEngine hash.Hash, file io.Reader, areasize int64, fileSize int64, tinyMultiplier int64) (string, error) {
 	var tinyfile bool = false
 	if file == nil {
 		return "", fmt.Errorf("nil reader")
@@ -103,6 +336,9 @@ func QuickHashGen(hashEngine hash.Hash, file io.Reader, areasize int64, fileSize
 	if areasize <= 0 {
 		return "", fmt.Errorf("invalid areasize: %d", areasize)
 	}
+	if tinyMultiplier < 1 {
+		return "", fmt.Errorf("invalid tiny multiplier: %d", tinyMultiplier)
+	}
 	seeker, ok := file.(io.Seeker)
 	if !ok {
 		return "", fmt.Errorf("file does not support seeking")
@@ -110,7 +346,7 @@ func QuickHashGen(hashEngine hash.Hash, file io.Reader, areasize int64, fileSize
 	var readsize int64
 
 	//when fullhash all looks tiny
-	if int64(BIG_MULTIPLIER)*areasize >= fileSize {
+	if tinyMultiplier*areasize >= fileSize {
 		//seek time has a cost, for these reason
 		tinyfile = true
 		readsize = fileSize
@@ -122,7 +358,7 @@ func QuickHashGen(hashEngine hash.Hash, file io.Reader, areasize int64, fileSize
 
 	//hashing
 	if fileSize != 0 {
-		if _, err := io.CopyN(hashEngine, file, readsize); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		if _, err := copyNPooled(hashEngine, file, readsize); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
 			//EOF are not considered errors, but simply end of the job
 			return "", fmt.Errorf("failed to hash: %w", err)
 		}
@@ -131,7 +367,7 @@ func QuickHashGen(hashEngine hash.Hash, file io.Reader, areasize int64, fileSize
 			if err != nil {
 				return "", fmt.Errorf("failed to seek to last %d bytes: %w", readsize, err)
 			}
-			if _, err := io.CopyN(hashEngine, file, readsize); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			if _, err := copyNPooled(hashEngine, file, readsize); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
 				//EOF are not considered errors, but simply end of the job
 				return "", fmt.Errorf("failed to hash: %w", err)
 			}
@@ -143,6 +379,9 @@ func QuickHashGen(hashEngine hash.Hash, file io.Reader, areasize int64, fileSize
 
 }
 
+// ErrNotRegular is returned by CheckFile for special files: devices, fifos, sockets
+var ErrNotRegular = errors.New("not a regular file")
+
 // checkFile performs common file checks for WalkDir callbacks.
 // Returns the absolute path and size for valid regular files, or empty string, zero size, and nil to skip,
 // or an error if ignoreErrors is false and a failure occurs.
@@ -166,7 +405,7 @@ func CheckFile(path string, d os.DirEntry, err error, recurse bool, rootPath str
 		return "", 0, nil
 	}
 	if !fileInfo.Mode().IsRegular() {
-		return "", 0, fmt.Errorf("%s is not a regular file", path)
+		return "", 0, fmt.Errorf("%s: %w", path, ErrNotRegular)
 	}
 	absPath, err := filepath.Abs(path)
 	if err != nil {
@@ -191,6 +430,84 @@ func MaxFilenameLength(paths []string) int {
 	return maxLen
 }
 
+// IsUnder tells if path is root or lies inside it, both must be clean.
+func IsUnder(path string, root string) bool {
+	if path == root {
+		return true
+	}
+	if !strings.HasSuffix(root, string(filepath.Separator)) {
+		root += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, root)
+}
+
+// IsUnderAny tells if path is under at least one of the roots.
+func IsUnderAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if IsUnder(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// AbsPaths converts a comma separated list of paths into clean absolute paths,
+// empty items are skipped.
+func AbsPaths(list string) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) != "" {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return AbsPathList(items)
+}
+
+// AbsPathList converts paths, e.g. the command line arguments, into clean
+// absolute paths. Unlike AbsPaths the paths may contain commas.
+func AbsPathList(paths []string) ([]string, error) {
+	absPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %v", path, err)
+		}
+		absPaths = append(absPaths, absPath)
+	}
+	return absPaths, nil
+}
+
+// PruneNestedPaths drops from paths those that are the same folder as, or are
+// under, another one (compared as absolute clean paths), the first spelling
+// is kept. Returns the kept paths, in their order, and the pruned ones.
+func PruneNestedPaths(paths []string) ([]string, []string, error) {
+	absPaths := make([]string, len(paths))
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get absolute path for %s: %v", path, err)
+		}
+		absPaths[i] = absPath
+	}
+	var kept, pruned []string
+	for i, path := range paths {
+		redundant := false
+		for j, other := range absPaths {
+			//a path equal to another is pruned only after the first of them
+			if j != i && IsUnder(absPaths[i], other) && (absPaths[i] != other || j < i) {
+				redundant = true
+				break
+			}
+		}
+		if redundant {
+			pruned = append(pruned, path)
+		} else {
+			kept = append(kept, path)
+		}
+	}
+	return kept, pruned, nil
+}
+
 func UserPathInfo() (string, error) {
 	currentUser, err := user.Current()
 	if err != nil {
@@ -202,10 +519,10 @@ func UserPathInfo() (string, error) {
 	// For a more robust check for sudo, you might check for SUDO_UID or other env vars,
 	// but checking UID 0 is the most direct for root.
 	if currentUser.Uid == "0" { // Root user
-		fmt.Fprintln(os.Stderr, "No path specified with -u/-U. Defaulting to ALL filesystem (root /) as current user is root.")
+		slog.Info("No path specified with -u/-U. Defaulting to ALL filesystem (root /) as current user is root.")
 		return "/", nil // Default to filesystem root
 	} else { // Normal user
-		fmt.Fprintf(os.Stderr, "No path specified with -u/-U. Defaulting to user home directory (%s).\n", currentUser.HomeDir)
+		slog.Info("No path specified with -u/-U. Defaulting to user home directory.", "path", currentUser.HomeDir)
 		return currentUser.HomeDir, nil // Default to user's home directory
 	}
 }
@@ -286,3 +603,34 @@ func HybridWalk(root string, fn HybridWalkFunc) error {
 
 	return walk(root)
 }
+
+// DirTracker remembers the folders already walked, by real path and by inode,
+// so that a folder reachable from several roots (symlinked parents, bind
+// mounts, overlapping paths) is walked only once.
+type DirTracker struct {
+	realPaths map[string]bool
+	ids       map[FileID]bool
+}
+
+func NewDirTracker() *DirTracker {
+	return &DirTracker{realPaths: make(map[string]bool), ids: make(map[FileID]bool)}
+}
+
+// FirstVisit records the folder and tells if it was not walked before.
+// Folders that cannot be resolved are always walked.
+func (t *DirTracker) FirstVisit(path string, d fs.DirEntry) bool {
+	first := true
+	if realPath, err := filepath.EvalSymlinks(path); err == nil {
+		if realPath, err = filepath.Abs(realPath); err == nil {
+			first = !t.realPaths[realPath]
+			t.realPaths[realPath] = true
+		}
+	}
+	if info, err := d.Info(); err == nil {
+		if id, _, ok := FileIdentity(info); ok {
+			first = first && !t.ids[id]
+			t.ids[id] = true
+		}
+	}
+	return first
+}
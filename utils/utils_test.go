@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"testing"
+)
+
+// The hashes of small files must not allocate a read buffer each, run with
+// -benchmem to compare the allocations per file of the pooled buffers.
+func BenchmarkHashGen(b *testing.B) {
+	content := bytes.Repeat([]byte("duplito"), 4096/7)
+	hashEngine := md5.New()
+	reader := bytes.NewReader(content)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	for i := 0; i < b.N; i++ {
+		reader.Reset(content)
+		if _, err := HashGen(hashEngine, reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQuickHashGen(b *testing.B) {
+	content := bytes.Repeat([]byte("duplito"), 64*1024/7)
+	hashEngine := md5.New()
+	reader := bytes.NewReader(content)
+	b.ReportAllocs()
+	b.SetBytes(4096) //the sampled head and tail
+	for i := 0; i < b.N; i++ {
+		reader.Reset(content)
+		if _, err := QuickHashGen(hashEngine, reader, 4096, int64(len(content)), DefaultTinyMultiplier); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"fmt"
+	"hash"
+)
+
+// FoldXattrs returns hashSum, the content hash of path, unchanged when path has
+// no extended attributes, otherwise the hash of hashSum followed by the
+// attributes: files with the same content and different attributes are no
+// longer duplicates.
+func FoldXattrs(hashEngine hash.Hash, hashSum string, path string) (string, error) {
+	xattrs, err := Xattrs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read extended attributes: %w", err)
+	}
+	if len(xattrs) == 0 {
+		return hashSum, nil
+	}
+	hashEngine.Reset()
+	hashEngine.Write([]byte(hashSum))
+	hashEngine.Write(xattrs)
+	return fmt.Sprintf("%x", hashEngine.Sum(nil)), nil
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// XattrsSupported tells if Xattrs can read the extended attributes
+const XattrsSupported = true
+
+// Xattrs returns the extended attributes of path, names and values, sorted by
+// name and encoded so that two files have the same bytes only when they have
+// the same attributes. Returns nil when the file has none.
+func Xattrs(path string) ([]byte, error) {
+	names, err := xattrCall(func(dest []byte) (int, error) { return syscall.Listxattr(path, dest) })
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	list := bytes.Split(bytes.TrimSuffix(names, []byte{0}), []byte{0})
+	sort.Slice(list, func(i, j int) bool { return bytes.Compare(list[i], list[j]) < 0 })
+	var out bytes.Buffer
+	for _, name := range list {
+		value, err := xattrCall(func(dest []byte) (int, error) { return syscall.Getxattr(path, string(name), dest) })
+		if err != nil {
+			return nil, err
+		}
+		out.Write(name)
+		out.WriteByte(0)
+		out.WriteString(strconv.Itoa(len(value)) + ":")
+		out.Write(value)
+	}
+	return out.Bytes(), nil
+}
+
+// xattrCall asks the size first and then reads, again when the attributes grew
+// in the meantime (ERANGE).
+func xattrCall(call func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		dest := make([]byte, size)
+		size, err = call(dest)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dest[:size], nil
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+// XattrsSupported tells if Xattrs can read the extended attributes
+const XattrsSupported = false
+
+// Xattrs is not supported on this platform.
+func Xattrs(path string) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,164 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// ActionLog records a destructive action so that it can be resumed when it is
+// interrupted (--action-log, --resume-action). The whole plan is written up
+// front, one "delete" line per deletion, then a "done" line is appended after
+// each file is deleted. Paths are quoted (strconv.Quote), any name survives.
+type ActionLog struct {
+	file *os.File
+}
+
+// CreateActionLog writes the plan to a new log at logPath, synced to disk
+// before any file is deleted.
+func CreateActionLog(logPath string, plan DeletePlan) (*ActionLog, error) {
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create action log: %w", err)
+	}
+	bw := bufio.NewWriter(file)
+	for _, del := range plan.Deletions {
+		fmt.Fprintf(bw, "delete %s %s %d %s\n",
+			strconv.Quote(del.Path), strconv.Quote(del.Keeper), del.HashPair.Filesize, strconv.Quote(del.HashPair.Hash))
+	}
+	if err = bw.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write action log: %w", err)
+	}
+	return &ActionLog{file: file}, nil
+}
+
+// OpenActionLog opens an existing log to append the deletions done on resume.
+// A last line cut by the interruption is dropped first.
+func OpenActionLog(logPath string) (*ActionLog, error) {
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open action log: %w", err)
+	}
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open action log: %w", err)
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		if err = file.Truncate(int64(bytes.LastIndexByte(content, '\n') + 1)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write action log: %w", err)
+		}
+	}
+	return &ActionLog{file: file}, nil
+}
+
+// Done records that path was deleted.
+func (l *ActionLog) Done(path string) error {
+	_, err := fmt.Fprintf(l.file, "done %s\n", strconv.Quote(path))
+	return err
+}
+
+func (l *ActionLog) Close() error {
+	return l.file.Close()
+}
+
+// LoadActionLog reads a log and returns the plan it records and the paths
+// already deleted. A malformed last line is ignored, it is the line being
+// written when the action was interrupted.
+func LoadActionLog(logPath string) (DeletePlan, map[string]bool, error) {
+	var plan DeletePlan
+	done := make(map[string]bool)
+	file, err := os.Open(logPath)
+	if err != nil {
+		return plan, nil, fmt.Errorf("failed to open action log: %w", err)
+	}
+	defer file.Close()
+
+	var lineErr error //error of the previous line, fatal only if it is not the last one
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if lineErr != nil {
+			return plan, nil, lineErr
+		}
+		op, rest, _ := strings.Cut(scanner.Text(), " ")
+		switch op {
+		case "delete":
+			del, err := parseDeletion(rest)
+			if err != nil {
+				lineErr = fmt.Errorf("action log line %d: %w", lineNum, err)
+				continue
+			}
+			plan.Deletions = append(plan.Deletions, del)
+			plan.Size += del.HashPair.Filesize
+		case "done":
+			path, _, err := unquoteField(rest)
+			if err != nil {
+				lineErr = fmt.Errorf("action log line %d: %w", lineNum, err)
+				continue
+			}
+			done[path] = true
+		default:
+			lineErr = fmt.Errorf("action log line %d: unknown operation %q", lineNum, op)
+		}
+	}
+	return plan, done, scanner.Err()
+}
+
+// parseDeletion parses the fields of a delete line: path, keeper, size, hash.
+func parseDeletion(fields string) (Deletion, error) {
+	var del Deletion
+	var err error
+	if del.Path, fields, err = unquoteField(fields); err != nil {
+		return del, err
+	}
+	if del.Keeper, fields, err = unquoteField(fields); err != nil {
+		return del, err
+	}
+	size, fields, _ := strings.Cut(fields, " ")
+	if del.HashPair.Filesize, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return del, err
+	}
+	del.HashPair.Hash, _, err = unquoteField(fields)
+	return del, err
+}
+
+// unquoteField returns the quoted string at the start of s and what follows it.
+func unquoteField(s string) (string, string, error) {
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", s, err
+	}
+	value, _ := strconv.Unquote(quoted)
+	return value, strings.TrimPrefix(s[len(quoted):], " "), nil
+}
+
+// Remaining returns the deletions of p not done yet, and removes from hashMap
+// the done ones (the database may not have been saved by the interrupted run).
+// A planned path that no longer exists is done too: the run was interrupted
+// between its deletion and the done record. The other copies of its group are
+// verified again by Execute.
+func (p DeletePlan) Remaining(done map[string]bool, hashMap map[utils.HashPair][]string) DeletePlan {
+	var remaining DeletePlan
+	for _, del := range p.Deletions {
+		_, err := os.Lstat(del.Path)
+		if os.IsNotExist(err) {
+			hashMap[del.HashPair] = otherPaths(del.Path, hashMap[del.HashPair])
+			continue
+		}
+		if done[del.Path] {
+			continue //deleted, and a file with the same name created since
+		}
+		remaining.Deletions = append(remaining.Deletions, del)
+		remaining.Size += del.HashPair.Filesize
+	}
+	return remaining
+}
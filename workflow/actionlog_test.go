@@ -0,0 +1,107 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// The plan and the done paths are read back whatever the names, a last line
+// cut by the interruption is ignored and a malformed line before it is an error.
+func TestLoadActionLog(t *testing.T) {
+	odd := Deletion{Path: "/d/a \"b\"\nc", Keeper: "/k/a b", HashPair: utils.HashPair{Filesize: 3, Hash: "h1"}}
+	plain := Deletion{Path: "/d/x", Keeper: "/k/x", HashPair: utils.HashPair{Filesize: 5, Hash: "h2"}}
+	tests := []struct {
+		name    string
+		log     string
+		want    []Deletion
+		done    map[string]bool
+		wantErr bool
+	}{
+		{
+			name: "quoted paths",
+			log:  "delete \"/d/a \\\"b\\\"\\nc\" \"/k/a b\" 3 \"h1\"\ndelete \"/d/x\" \"/k/x\" 5 \"h2\"\ndone \"/d/a \\\"b\\\"\\nc\"\n",
+			want: []Deletion{odd, plain},
+			done: map[string]bool{odd.Path: true},
+		},
+		{
+			name: "truncated delete line",
+			log:  "delete \"/d/x\" \"/k/x\" 5 \"h2\"\ndelete \"/d/y\" \"/k/y\" 5",
+			want: []Deletion{plain},
+			done: map[string]bool{},
+		},
+		{
+			name: "truncated done line",
+			log:  "delete \"/d/x\" \"/k/x\" 5 \"h2\"\ndone \"/d/",
+			want: []Deletion{plain},
+			done: map[string]bool{},
+		},
+		{
+			name:    "malformed line in the middle",
+			log:     "delete \"/d/x\" \"/k/x\" five \"h2\"\ndone \"/d/x\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown operation",
+			log:     "move \"/d/x\"\ndone \"/d/x\"\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logPath := filepath.Join(t.TempDir(), "action.log")
+			if err := os.WriteFile(logPath, []byte(tt.log), 0600); err != nil {
+				t.Fatal(err)
+			}
+			plan, done, err := LoadActionLog(logPath)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("no error, plan %v", plan.Deletions)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(plan.Deletions, tt.want) {
+				t.Errorf("deletions %v, want %v", plan.Deletions, tt.want)
+			}
+			if !reflect.DeepEqual(done, tt.done) {
+				t.Errorf("done %v, want %v", done, tt.done)
+			}
+		})
+	}
+}
+
+// A planned path already gone is done and leaves the database, a done path
+// created again is neither deleted nor removed from the database.
+func TestRemaining(t *testing.T) {
+	dir := t.TempDir()
+	pair := utils.HashPair{Filesize: 3, Hash: "h"}
+	keeper := filepath.Join(dir, "keep")
+	present := filepath.Join(dir, "present")
+	gone := filepath.Join(dir, "gone")
+	recreated := filepath.Join(dir, "recreated")
+	for _, path := range []string{keeper, present, recreated} {
+		if err := os.WriteFile(path, []byte("dup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan := DeletePlan{Size: 9}
+	for _, path := range []string{present, gone, recreated} {
+		plan.Deletions = append(plan.Deletions, Deletion{Path: path, Keeper: keeper, HashPair: pair})
+	}
+	hashMap := map[utils.HashPair][]string{pair: {keeper, present, gone, recreated}}
+
+	remaining := plan.Remaining(map[string]bool{recreated: true}, hashMap)
+
+	if len(remaining.Deletions) != 1 || remaining.Deletions[0].Path != present || remaining.Size != 3 {
+		t.Errorf("remaining %v (%d bytes), want only %s (3 bytes)", remaining.Deletions, remaining.Size, present)
+	}
+	if want := []string{keeper, present, recreated}; !reflect.DeepEqual(hashMap[pair], want) {
+		t.Errorf("group %v, want %v", hashMap[pair], want)
+	}
+}
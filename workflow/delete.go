@@ -0,0 +1,229 @@
+package workflow
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// Deletion is a duplicate to remove together with the copy that is kept.
+type Deletion struct {
+	Path     string
+	Keeper   string
+	HashPair utils.HashPair
+}
+
+// DeletePlan lists the duplicates that --keep-newest removes.
+type DeletePlan struct {
+	Deletions []Deletion
+	Size      int64 // bytes freed when every deletion succeeds
+}
+
+// PlanKeepNewest keeps, for each duplicate group of the database having copies
+// under roots, the copy with the newest modification time and plans the
+// deletion of the older copies under roots. Copies outside roots are never
+// deleted but can be the kept one. Copies under keepUnder (--keep-under) are
+// never deleted, and the kept copy is the newest of them when the group has
+// any. Copies that are missing or whose size differs from the database are
+// left out of the group, groups of partial hashes (--resume-hash) are skipped.
+func PlanKeepNewest(hashMap map[utils.HashPair][]string, roots []string, keepUnder []string) DeletePlan {
+	var plan DeletePlan
+	for hashPair, group := range hashMap {
+		if hashPair.Hash == "" || utils.IsPartialHash(hashPair.Hash) || len(group) < 2 || !anyUnder(group, roots) {
+			continue
+		}
+		var keeper string
+		var keeperTime int64
+		var keeperProtected bool
+		var present []string
+		for _, path := range group {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() || info.Size() != hashPair.Filesize {
+				slog.Warn("skipping copy changed since the last update", "path", path)
+				continue
+			}
+			present = append(present, path)
+			//a --keep-under copy wins, then the newest, same time keeps the first by path order
+			protected := utils.IsUnderAny(path, keepUnder)
+			mtime := info.ModTime().UnixNano()
+			if keeper == "" || (protected && !keeperProtected) ||
+				(protected == keeperProtected && (mtime > keeperTime || (mtime == keeperTime && path < keeper))) {
+				keeper, keeperTime, keeperProtected = path, mtime, protected
+			}
+		}
+		for _, path := range present {
+			if path == keeper || !utils.IsUnderAny(path, roots) || utils.IsUnderAny(path, keepUnder) {
+				continue
+			}
+			if sameFile(path, keeper) {
+				slog.Warn("skipping copy, same file as the kept one", "path", path, "keeper", keeper)
+				continue
+			}
+			plan.Deletions = append(plan.Deletions, Deletion{Path: path, Keeper: keeper, HashPair: hashPair})
+			plan.Size += hashPair.Filesize
+		}
+	}
+	sort.Slice(plan.Deletions, func(i, j int) bool { return plan.Deletions[i].Path < plan.Deletions[j].Path })
+	return plan
+}
+
+// sameFile tells if the two paths are the same file (same device and inode):
+// a hardlink, or a path reaching the file through a bind mount or a symlinked
+// folder. Deleting such a "duplicate" would delete the kept copy, or free
+// nothing. Files that cannot be read are not the same file.
+func sameFile(pathA string, pathB string) bool {
+	infoA, err := os.Stat(pathA)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(pathB)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// sameContent compares two files byte by byte.
+func sameContent(pathA string, pathB string) (bool, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+	_, equal, err := utils.FirstDiffOffset(fileA, fileB)
+	return equal, err
+}
+
+// Execute deletes the planned duplicates and removes them from hashMap. Each
+// group is verified before any of its copies is deleted: the size of every
+// copy is checked again and its content is compared with the kept copy, a
+// quick hash match is not enough to delete a file. A copy whose size or
+// content no longer matches means the group changed since the last update,
+// the whole group is left alone and logged as refused. A copy that is the kept
+// file itself (same inode) is never deleted. Failures are logged and skipped.
+// Each deletion is recorded in actionLog, when not nil. Returns the paths
+// deleted and the bytes freed.
+func (p DeletePlan) Execute(hashMap map[utils.HashPair][]string, actionLog *ActionLog) ([]string, int64) {
+	var deleted []string
+	var freed int64
+	var order []utils.HashPair //groups in plan order
+	groups := make(map[utils.HashPair][]Deletion)
+	for _, del := range p.Deletions {
+		if _, ok := groups[del.HashPair]; !ok {
+			order = append(order, del.HashPair)
+		}
+		groups[del.HashPair] = append(groups[del.HashPair], del)
+	}
+	for _, hashPair := range order {
+		verified, ok := verifyGroup(groups[hashPair])
+		if !ok {
+			slog.Warn("refusing to act on duplicate group, changed since the last update",
+				"size", hashPair.Filesize, "hash", hashPair.Hash, "keeper", groups[hashPair][0].Keeper)
+			continue
+		}
+		for _, del := range verified {
+			if err := os.Remove(del.Path); err != nil {
+				slog.Error("failed to delete file", "path", del.Path, "err", err)
+				continue
+			}
+			if actionLog != nil {
+				if err := actionLog.Done(del.Path); err != nil {
+					slog.Error("failed to record deletion in the action log", "path", del.Path, "err", err)
+				}
+			}
+			hashMap[del.HashPair] = otherPaths(del.Path, hashMap[del.HashPair])
+			deleted = append(deleted, del.Path)
+			freed += del.HashPair.Filesize
+		}
+	}
+	return deleted, freed
+}
+
+// verifyGroup checks the planned deletions of a duplicate group against the
+// disk, the plan can be stale. It returns the deletions to run, without the
+// copies that are the kept file itself, and false when a copy changed size or
+// content: then nothing of the group must be deleted.
+func verifyGroup(dels []Deletion) ([]Deletion, bool) {
+	var verified []Deletion
+	for _, del := range dels {
+		info, err := os.Stat(del.Path)
+		if err != nil || info.Size() != del.HashPair.Filesize {
+			slog.Warn("not deleting, file changed since the last update", "path", del.Path)
+			return nil, false
+		}
+		if sameFile(del.Path, del.Keeper) {
+			slog.Warn("not deleting, same file as the kept copy", "path", del.Path, "keeper", del.Keeper)
+			continue
+		}
+		equal, err := sameContent(del.Keeper, del.Path)
+		if err != nil || !equal {
+			slog.Warn("not deleting, content differs from the kept copy", "path", del.Path, "keeper", del.Keeper, "err", err)
+			return nil, false
+		}
+		verified = append(verified, del)
+	}
+	return verified, true
+}
+
+// RemoveEmptyDirs removes the folders left empty by the deletion of the
+// deleted files: the folder of each deleted file and its parents, up to the
+// roots excluded, deepest first so that a parent emptied by the removal of its
+// subfolders is removed too. A folder is removed only when it has no entries
+// at all, so folders still holding other files or subfolders are kept.
+// Returns the number of folders removed.
+func RemoveEmptyDirs(deleted []string, roots []string) int {
+	candidates := make(map[string]bool)
+	for _, path := range deleted {
+		for dir := filepath.Dir(path); !candidates[dir] && utils.IsUnderAny(dir, roots) && !slices.Contains(roots, dir); dir = filepath.Dir(dir) {
+			candidates[dir] = true
+		}
+	}
+	dirs := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		depthI := strings.Count(dirs[i], string(filepath.Separator))
+		depthJ := strings.Count(dirs[j], string(filepath.Separator))
+		if depthI != depthJ {
+			return depthI > depthJ
+		}
+		return dirs[i] < dirs[j]
+	})
+	numRemoved := 0
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		if err = os.Remove(dir); err != nil {
+			slog.Warn("failed to remove empty folder", "path", dir, "err", err)
+			continue
+		}
+		slog.Debug("removed empty folder", "path", dir)
+		numRemoved++
+	}
+	return numRemoved
+}
+
+// StringSummary returns the plan as one line per deletion followed by the totals.
+func (p DeletePlan) StringSummary() string {
+	var sb strings.Builder
+	for _, del := range p.Deletions {
+		fmt.Fprintf(&sb, "  DELETE %s\n%s(keeping %s)\n",
+			utils.EscapeControl(del.Path), indent, utils.EscapeControl(del.Keeper))
+	}
+	fmt.Fprintf(&sb, "\tTO DELETE:\t%-20dSIZE: %s\n", len(p.Deletions), utils.RepresentBytes(p.Size))
+	return sb.String()
+}
@@ -0,0 +1,39 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A folder emptied only once its subfolder is removed must be removed too,
+// whatever the order of the deleted files.
+func TestRemoveEmptyDirsParentEmptiedLater(t *testing.T) {
+	root := t.TempDir()
+	fileA := filepath.Join(root, "x", "a")
+	fileB := filepath.Join(root, "x", "y", "b")
+	for _, path := range []string{fileA, fileB} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("dup"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted := []string{fileA, fileB}
+	for _, path := range deleted {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if removed := RemoveEmptyDirs(deleted, []string{root}); removed != 2 {
+		t.Errorf("removed %d folders, want 2", removed)
+	}
+	if _, err := os.Stat(filepath.Join(root, "x")); !os.IsNotExist(err) {
+		t.Errorf("folder x still exists (err %v)", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("root removed: %v", err)
+	}
+}
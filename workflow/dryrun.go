@@ -0,0 +1,146 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	cfg "github.com/ftarlao/duplito/config"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// UpdatePlan summarizes what an update would do, compared to the current database.
+// The database does not store modification times, changes are detected by size.
+type UpdatePlan struct {
+	NumNew       int64 // files not in the database
+	NumChanged   int64 // files with a size different from the database
+	NumUnchanged int64
+	NumToHash    int64 // files sharing their size with another file, to be read
+	SizeToHash   int64 // upper bound of the bytes to read (quick hash reads less)
+}
+
+// DryRunUpdate walks the paths exactly like CalculateFileHashes, with the same
+// filters, but it reads no file content and it does not change the database.
+func DryRunUpdate(
+	paths []string,
+	opt cfg.Options,
+	reverseHashMap map[string]utils.HashPair,
+) (UpdatePlan, error) {
+	var plan UpdatePlan
+	classify := func(absPath string, size int64) {
+		hashPair, exists := reverseHashMap[absPath]
+		switch {
+		case !exists:
+			plan.NumNew++
+		case hashPair.Filesize != size:
+			plan.NumChanged++
+		default:
+			plan.NumUnchanged++
+		}
+	}
+
+	err := walkOnly(paths, opt,
+		func(task fileTask) {
+			if !task.IsUpdate {
+				classify(task.AbsPath, task.Filesize)
+			}
+			plan.NumToHash++
+			plan.SizeToHash += task.Filesize
+		},
+		func(res fileResult) {
+			classify(res.Path, res.HashPairID.Filesize)
+		})
+	return plan, err
+}
+
+// walkOnly runs the walk of an update without hashing: onTask receives the
+// tasks for the workers, onResult the files not to hash. Every file arrives
+// once, as a task (same size as another file) or as a result (unique size);
+// promoted first files come back as IsUpdate tasks.
+func walkOnly(paths []string, opt cfg.Options, onTask func(fileTask), onResult func(fileResult)) error {
+	skipMagics, err := utils.ParseMagics(opt.SkipMagic)
+	if err != nil {
+		return fmt.Errorf("invalid --skip-magic: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tasks := make(chan fileTask, 16)
+	results := make(chan fileResult, 16)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var progress walkProgress
+	go findFiles(paths, tasks, results, &wg, opt, ctx, nil, skipMagics, nil, &progress)
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for tasks != nil || results != nil {
+		select {
+		case task, ok := <-tasks:
+			if !ok {
+				tasks = nil
+				continue
+			}
+			onTask(task)
+		case res, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			if res.Err == nil { //unreadable files are not planned
+				onResult(res)
+			}
+		}
+	}
+	return nil
+}
+
+// SizeEstimate is the upper bound of the duplicates under some paths, from the
+// filesizes only: files with a unique size cannot be duplicates.
+type SizeEstimate struct {
+	NumFiles        int64 // files walked
+	NumGroups       int64 // filesizes shared by more files
+	NumSharing      int64 // files sharing their size
+	SizeReclaimable int64 // bytes removable if every group were all duplicates, keeping one copy
+}
+
+// EstimateDuplicates walks the paths like an update, reading no content, and
+// returns the upper bound of the reclaimable space.
+func EstimateDuplicates(paths []string, opt cfg.Options) (SizeEstimate, error) {
+	var estimate SizeEstimate
+	filesBySize := make(map[int64]int64)
+	add := func(size int64) {
+		estimate.NumFiles++
+		filesBySize[size]++
+	}
+	err := walkOnly(paths, opt,
+		func(task fileTask) {
+			if !task.IsUpdate {
+				add(task.Filesize)
+			}
+		},
+		func(res fileResult) {
+			add(res.HashPairID.Filesize)
+		})
+	for size, numFiles := range filesBySize {
+		if numFiles > 1 && size > 0 {
+			estimate.NumGroups++
+			estimate.NumSharing += numFiles
+			estimate.SizeReclaimable += size * (numFiles - 1)
+		}
+	}
+	return estimate, err
+}
+
+// StringSummary renders the estimate like the other summaries.
+func (e SizeEstimate) StringSummary() string {
+	return fmt.Sprintf("\tFILES:\t\t%-20dSIZE GROUPS: %d\n\tSHARING SIZE:\t%-20dRECLAIMABLE: up to %s\n",
+		e.NumFiles, e.NumGroups, e.NumSharing, utils.RepresentBytes(e.SizeReclaimable))
+}
+
+// StringSummary renders the plan like the other summaries.
+func (p UpdatePlan) StringSummary() string {
+	return fmt.Sprintf("\tNEW:\t\t%d\n\tCHANGED:\t%-20d(size differs from the database)\n\tUNCHANGED:\t%d\n\tTO HASH:\t%-20dREAD: up to %s\n",
+		p.NumNew, p.NumChanged, p.NumUnchanged, p.NumToHash, utils.RepresentBytes(p.SizeToHash))
+}
@@ -0,0 +1,63 @@
+package workflow
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// fileError is a file that could not be read by an update, with the reason
+type fileError struct {
+	path string
+	err  error
+}
+
+// fileErrors collects the files that could not be read, from the walk and
+// from the collector, to report them together at the end of the update.
+type fileErrors struct {
+	mu      sync.Mutex
+	entries []fileError
+}
+
+func (e *fileErrors) add(path string, err error) {
+	e.mu.Lock()
+	e.entries = append(e.entries, fileError{path: path, err: err})
+	e.mu.Unlock()
+}
+
+// report prints the files that could not be read and, when logPath is not
+// empty (--error-log), writes all of them to logPath. With a log only the
+// first ones are printed. The log is written even when empty, a log left by
+// a previous update would be taken for the errors of this one.
+func (e *fileErrors) report(logPath string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	const maxShown = 20
+	if len(e.entries) > 0 {
+		fmt.Printf("\n%d files could not be read:\n", len(e.entries))
+	}
+	for i, entry := range e.entries {
+		if logPath != "" && i == maxShown {
+			fmt.Printf("... and %d more, see %s\n", len(e.entries)-maxShown, logPath)
+			break
+		}
+		fmt.Printf("  %s: %v\n", utils.EscapeControl(entry.path), entry.err)
+	}
+	if logPath == "" {
+		return
+	}
+	file, err := os.Create(logPath)
+	if err != nil {
+		slog.Error("failed to create error log", "path", logPath, "err", err)
+		return
+	}
+	for _, entry := range e.entries {
+		fmt.Fprintf(file, "%s: %v\n", utils.EscapeControl(entry.path), entry.err)
+	}
+	if err = file.Close(); err != nil {
+		slog.Error("failed to write error log", "path", logPath, "err", err)
+	}
+}
@@ -0,0 +1,73 @@
+package workflow
+
+import (
+	"sync"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// folderJob is a folder whose files are ready to be classified.
+type folderJob struct {
+	files      []string
+	dir        string
+	sizeByFile map[string]int64
+	chunked    bool //part of a folder streamed in chunks (--stream-chunk)
+	lastChunk  bool
+	root       int //index of the walked path the folder belongs to
+	done       chan folderOutput
+}
+
+// folderPool classifies folders on a pool of goroutines and emits the outputs
+// in submission order, so that the listing keeps the walk order.
+type folderPool struct {
+	jobs    chan *folderJob
+	pending chan *folderJob // same jobs, in submission order, for the printer
+	workers sync.WaitGroup
+	printer sync.WaitGroup
+}
+
+// newFolderPool starts numWorkers goroutines running process, and a printer
+// goroutine that calls emit for each output. Values < 1 mean one worker.
+func newFolderPool(
+	numWorkers int,
+	process func(*folderJob) folderOutput,
+	emit func(*folderJob, folderOutput),
+) *folderPool {
+	numWorkers = utils.Max(numWorkers, 1)
+	p := &folderPool{
+		jobs:    make(chan *folderJob, numWorkers),
+		pending: make(chan *folderJob, numWorkers*2),
+	}
+	for i := 0; i < numWorkers; i++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				job.done <- process(job)
+			}
+		}()
+	}
+	p.printer.Add(1)
+	go func() {
+		defer p.printer.Done()
+		for job := range p.pending {
+			emit(job, <-job.done)
+		}
+	}()
+	return p
+}
+
+// submit queues a folder, blocks when the printer is too far behind.
+func (p *folderPool) submit(job *folderJob) {
+	job.done = make(chan folderOutput, 1)
+	p.pending <- job
+	p.jobs <- job
+}
+
+// close waits until every submitted folder has been emitted.
+func (p *folderPool) close() {
+	close(p.jobs)
+	close(p.pending)
+	p.workers.Wait()
+	p.printer.Wait()
+}
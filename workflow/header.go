@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	cfg "github.com/ftarlao/duplito/config"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// ReportHeader is the provenance of a listing printed by --report-header: what
+// was listed, how, and the database it was compared with.
+type ReportHeader struct {
+	Generated  time.Time  `json:"generated"`
+	Version    string     `json:"duplito_version"`
+	Paths      []string   `json:"paths"`
+	Arguments  []string   `json:"arguments"`             // command line options and paths
+	HashMode   string     `json:"hash_mode"`             // e.g. "md5 full (-U)", "unknown" without metadata
+	LastUpdate *time.Time `json:"last_update,omitempty"` // last complete update of the database, nil when unknown
+	Roots      []string   `json:"database_roots,omitempty"`
+}
+
+// NewReportHeader builds the header of a listing of paths, metadata is the
+// database metadata, nil when unknown.
+func NewReportHeader(paths []string, args []string, metadata *cfg.Metadata) ReportHeader {
+	header := ReportHeader{
+		Generated: time.Now(),
+		Version:   "unknown",
+		Paths:     paths,
+		Arguments: args,
+		HashMode:  "unknown",
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		header.Version = info.Main.Version + " " + info.GoVersion
+	}
+	if metadata != nil {
+		switch {
+		case !metadata.FullHash:
+			header.HashMode = "md5 quick (-u), sampled content"
+			if metadata.FullHashAbove > 0 {
+				header.HashMode += fmt.Sprintf(", full above %d bytes", metadata.FullHashAbove)
+			}
+			if metadata.QuickTinyMultiplier() != utils.DefaultTinyMultiplier {
+				header.HashMode += fmt.Sprintf(", full read up to %d areas", metadata.QuickTinyMultiplier())
+			}
+		case metadata.SparseAware:
+			header.HashMode = "md5 full (-U --sparse-aware)"
+		case metadata.MaxHashBytes > 0:
+			header.HashMode = fmt.Sprintf("md5 full (-U) of the first %d bytes", metadata.MaxHashBytes)
+		default:
+			header.HashMode = "md5 full (-U)"
+		}
+		if metadata.IncludeXattrs {
+			header.HashMode += ", extended attributes included"
+		}
+		header.LastUpdate = &metadata.LastUpdate
+		header.Roots = metadata.Roots
+	}
+	return header
+}
+
+// Write prints the header as comment lines, or as a single JSON line with
+// the schema version when asJSON.
+func (h ReportHeader) Write(w io.Writer, asJSON bool) {
+	if asJSON {
+		line, _ := json.Marshal(struct {
+			Version int          `json:"version"`
+			Header  ReportHeader `json:"header"`
+		}{JSONSchemaVersion, h})
+		w.Write(append(line, '\n'))
+		return
+	}
+	lastUpdate := "unknown"
+	if h.LastUpdate != nil {
+		lastUpdate = h.LastUpdate.Format(time.DateTime)
+	}
+	fmt.Fprintf(w, "# duplito report generated %s, version %s\n", h.Generated.Format(time.DateTime), h.Version)
+	fmt.Fprintf(w, "# paths: %s\n", utils.EscapeControl(strings.Join(h.Paths, " ")))
+	fmt.Fprintf(w, "# arguments: %s\n", utils.EscapeControl(strings.Join(h.Arguments, " ")))
+	fmt.Fprintf(w, "# hash: %s, database updated %s\n", h.HashMode, lastUpdate)
+	if len(h.Roots) > 0 {
+		fmt.Fprintf(w, "# database roots: %s\n", utils.EscapeControl(strings.Join(h.Roots, " ")))
+	}
+}
@@ -0,0 +1,100 @@
+package workflow
+
+import (
+	"html/template"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+
+	counters "github.com/ftarlao/duplito/counters"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// htmlCopy is a file of a duplicate group in the HTML report
+type htmlCopy struct {
+	Path string
+	URL  template.URL // file:// link, built by fileURL
+}
+
+// htmlGroup is a duplicate group in the HTML report
+type htmlGroup struct {
+	Size        string
+	Reclaimable string
+	Hash        string
+	Copies      []htmlCopy
+}
+
+// htmlReport is the data rendered by htmlReportTemplate
+type htmlReport struct {
+	Summary string
+	Groups  []htmlGroup
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>duplito duplicates report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f4f4f4; padding: 1em; }
+summary { cursor: pointer; padding: 0.2em 0; }
+li { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Duplicates report</h1>
+<pre>{{.Summary}}</pre>
+<h2>{{len .Groups}} duplicate groups</h2>
+{{range .Groups}}<details>
+<summary>{{len .Copies}} copies of {{.Size}}, reclaimable {{.Reclaimable}}</summary>
+<ul>
+{{range .Copies}}<li><a href="{{.URL}}">{{.Path}}</a></li>
+{{end}}</ul>
+<small>hash {{.Hash}}</small>
+</details>
+{{end}}</body>
+</html>
+`))
+
+// fileURL returns the file:// link of an absolute path
+func fileURL(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// WriteHTMLReport writes an HTML page with the statistics of the database files
+// under roots (all the files when roots is empty) and a collapsible block for
+// each duplicate group having a copy under roots, biggest reclaimable first.
+// Returns the number of groups.
+func WriteHTMLReport(w io.Writer, hashMap map[utils.HashPair][]string, roots []string) (int, error) {
+	var stats counters.Stats
+	for hashPair, paths := range hashMap {
+		for _, path := range paths {
+			if len(roots) > 0 && !utils.IsUnderAny(path, roots) {
+				continue
+			}
+			if len(paths) > 1 {
+				stats.AddDupFile(hashPair.Filesize)
+			} else {
+				stats.AddUniqueFile(hashPair.Filesize)
+			}
+		}
+	}
+
+	report := htmlReport{Summary: stats.StringSummary()}
+	for _, hashPair := range duplicateGroups(hashMap, roots) {
+		paths := append([]string(nil), hashMap[hashPair]...)
+		sort.Strings(paths)
+		group := htmlGroup{
+			Size:        utils.RepresentBytes(hashPair.Filesize),
+			Reclaimable: utils.RepresentBytes(hashPair.Filesize * int64(len(paths)-1)),
+			Hash:        hashPair.Hash,
+		}
+		for _, path := range paths {
+			group.Copies = append(group.Copies, htmlCopy{Path: path, URL: template.URL(fileURL(path))})
+		}
+		report.Groups = append(report.Groups, group)
+	}
+	return len(report.Groups), htmlReportTemplate.Execute(w, report)
+}
@@ -0,0 +1,135 @@
+package workflow
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"log/slog"
+	"os"
+	"sort"
+
+	config "github.com/ftarlao/duplito/config"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// rehash hashes path the way the database described by metadata was built,
+// nil metadata means the default quick hash.
+func rehash(path string, filesize int64, metadata *config.Metadata, hashEngine hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var hashSum string
+	switch {
+	case metadata.QuickHashed(filesize):
+		hashSum, err = utils.QuickHashGen(hashEngine, file, quickHashArea, filesize, metadata.QuickTinyMultiplier())
+	case metadata.MaxHashBytes > 0:
+		hashSum, err = utils.HashGenN(hashEngine, file, metadata.MaxHashBytes)
+	case metadata.SparseAware:
+		hashSum, err = utils.SparseHashGen(hashEngine, file, filesize)
+	default:
+		hashSum, err = utils.HashGen(hashEngine, file)
+	}
+	if err == nil && metadata != nil && metadata.IncludeXattrs {
+		hashSum, err = utils.FoldXattrs(hashEngine, hashSum, path)
+	}
+	return hashSum, err
+}
+
+// changedFile is a catalogued file whose content no longer matches the database
+type changedFile struct {
+	path   string
+	reason string
+}
+
+// verifySymlink returns why the symbolic link at path no longer matches its
+// database entry, empty when it still points to the same target.
+func verifySymlink(path string, hashPair utils.HashPair) string {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "MISSING"
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "NOT A SYMLINK ANYMORE"
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return fmt.Sprintf("UNREADABLE (%v)", err)
+	}
+	if int64(len(target)) != hashPair.Filesize || utils.SymlinkHash(target) != hashPair.Hash {
+		return "TARGET CHANGED"
+	}
+	return ""
+}
+
+// VerifyReport hashes again the catalogued files under roots (all the database
+// when roots is empty) and prints those whose size or hash differs from the
+// database, and those missing. Files with a unique size were never hashed,
+// only their size is checked. Partially hashed files (--resume-hash) cannot be
+// verified, they are listed apart. Symbolic links (--hash-symlinks) are
+// checked by their target path, the target is never read. Returns the number
+// of changed and missing files.
+func VerifyReport(hashMap map[utils.HashPair][]string, roots []string, metadata *config.Metadata) int {
+	if metadata == nil || !metadata.FullHash {
+		slog.Warn("the database was built with the quick hash (-u), changes outside the sampled areas go unnoticed")
+	}
+	hashEngine := md5.New()
+	var changed []changedFile
+	var partial []string
+	var numChecked, numSizeOnly int
+	config.EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		if len(roots) > 0 && !utils.IsUnderAny(path, roots) {
+			return
+		}
+		numChecked++
+		if utils.IsSymlinkHash(hashPair.Hash) {
+			if reason := verifySymlink(path, hashPair); reason != "" {
+				changed = append(changed, changedFile{path: path, reason: reason})
+			}
+			return
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			changed = append(changed, changedFile{path: path, reason: "MISSING"})
+			return
+		case info.Size() != hashPair.Filesize:
+			changed = append(changed, changedFile{path: path, reason: fmt.Sprintf("SIZE CHANGED (%s, was %s)",
+				utils.RepresentBytes(info.Size()), utils.RepresentBytes(hashPair.Filesize))})
+			return
+		case hashPair.Hash == "":
+			numSizeOnly++
+			return
+		case utils.IsPartialHash(hashPair.Hash):
+			partial = append(partial, path)
+			return
+		}
+		slog.Debug("verifying file", "path", path)
+		hashSum, err := rehash(path, hashPair.Filesize, metadata, hashEngine)
+		if err != nil {
+			changed = append(changed, changedFile{path: path, reason: fmt.Sprintf("UNREADABLE (%v)", err)})
+		} else if hashSum != hashPair.Hash {
+			changed = append(changed, changedFile{path: path, reason: "CONTENT CHANGED"})
+		}
+	})
+	sort.Slice(changed, func(i, j int) bool { return changed[i].path < changed[j].path })
+	sort.Strings(partial)
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("VERIFY AGAINST DATABASE")
+	fmt.Printf("\tFILES:\t\t%-20dCHANGED: %d\n", numChecked, len(changed))
+	fmt.Printf("\tSIZE ONLY:\t%d (unique size, never hashed)\n", numSizeOnly)
+	fmt.Printf("\tPARTIAL:\t%d (read error at the last update, not verified)\n", len(partial))
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, file := range changed {
+		fmt.Printf("  %s %s\n", file.reason, utils.EscapeControl(file.path))
+	}
+	for _, path := range partial {
+		fmt.Printf("  PARTIAL %s\n", utils.EscapeControl(path))
+	}
+	if len(changed) > 0 || len(partial) > 0 {
+		utils.PrintSeparator(SEP_WIDTH)
+	}
+	return len(changed)
+}
@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"fmt"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// Palette holds the color (and optional mark) used for each kind of output,
+// all the colored output of the listing goes through it.
+type Palette struct {
+	Header        string // folder headers
+	Warning       string // zero size and not in database files
+	Unique        string
+	Duplicate     string
+	DupPath       string // locations of the duplicates
+	Reset         string
+	HeaderReset   string // Reset after the folder headers, empty when they are plain
+	UniqueMark    string // printed before the status, helps when colors are not distinguishable
+	DuplicateMark string
+}
+
+// ColorOrange is not in the basic 16 colors, it requires a 256 colors terminal
+const ColorOrange = "\033[38;5;208m"
+
+var palettes = map[string]Palette{
+	"default": {
+		Header:    ColorLightBlue,
+		Warning:   ColorYellow,
+		Unique:    ColorGreen,
+		Duplicate: ColorLightRed,
+		DupPath:   ColorCyan,
+		Reset:     ColorReset,
+	},
+	// blue/orange are distinguishable with the common color vision deficiencies
+	"colorblind": {
+		Header:        ColorWhite,
+		Warning:       ColorYellow,
+		Unique:        ColorBlue,
+		Duplicate:     ColorOrange,
+		DupPath:       ColorLightBlue,
+		Reset:         ColorReset,
+		UniqueMark:    "o ",
+		DuplicateMark: "X ",
+	},
+	"mono": {},
+}
+
+// ResolveColorScheme returns the scheme "auto" (or empty) stands for: default
+// on a terminal and mono when the output is redirected. Other values are
+// returned as they are.
+func ResolveColorScheme(scheme string) string {
+	if scheme != "auto" && scheme != "" {
+		return scheme
+	}
+	if utils.IsTerminal() {
+		return "default"
+	}
+	return "mono"
+}
+
+// PaletteFor returns the palette of a --color-scheme value, "auto" is
+// resolved by ResolveColorScheme.
+// sections (--color-sections) restricts the colors to the file lines
+// ("files"), to the folder headers with their summaries ("headers"), or
+// leaves both colored ("all" or empty).
+func PaletteFor(scheme string, sections string) (Palette, error) {
+	scheme = ResolveColorScheme(scheme)
+	palette, ok := palettes[scheme]
+	if !ok {
+		return Palette{}, fmt.Errorf("unknown color scheme '%s' (use auto, default, colorblind or mono)", scheme)
+	}
+	palette.HeaderReset = palette.Reset
+	switch sections {
+	case "all", "":
+	case "files":
+		palette.Header, palette.HeaderReset = "", ""
+	case "headers":
+		//the marks are not colors, they stay
+		palette.Warning, palette.Unique, palette.Duplicate, palette.DupPath, palette.Reset = "", "", "", "", ""
+	default:
+		return Palette{}, fmt.Errorf("unknown color sections '%s' (use all, files or headers)", sections)
+	}
+	return palette, nil
+}
@@ -0,0 +1,704 @@
+package workflow
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	config "github.com/ftarlao/duplito/config"
+	counters "github.com/ftarlao/duplito/counters"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// sizeCollision counts the catalogued files sharing one filesize
+type sizeCollision struct {
+	Filesize  int64
+	NumFiles  int
+	NumHashes int // distinct contents among the files
+}
+
+// SizeCollisionsReport prints the maxSizes filesizes shared by the most files in
+// the database, with how many distinct contents share each size. It shows how
+// much the size prefilter saves: only files in these groups are ever hashed.
+func SizeCollisionsReport(hashMap map[utils.HashPair][]string, maxSizes int) {
+	bySize := make(map[int64]*sizeCollision)
+	for hashPair, paths := range hashMap {
+		collision, ok := bySize[hashPair.Filesize]
+		if !ok {
+			collision = &sizeCollision{Filesize: hashPair.Filesize}
+			bySize[hashPair.Filesize] = collision
+		}
+		collision.NumFiles += len(paths)
+		collision.NumHashes++
+	}
+
+	var collisions []*sizeCollision
+	var numFiles, numShared int
+	for _, collision := range bySize {
+		numFiles += collision.NumFiles
+		if collision.NumFiles > 1 {
+			collisions = append(collisions, collision)
+			numShared += collision.NumFiles
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		if collisions[i].NumFiles != collisions[j].NumFiles {
+			return collisions[i].NumFiles > collisions[j].NumFiles
+		}
+		return collisions[i].Filesize < collisions[j].Filesize
+	})
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("SIZE COLLISIONS")
+	fmt.Printf("\tSHARED SIZES:\t%-20dFILES: %d of %d\n", len(collisions), numShared, numFiles)
+	utils.PrintSeparator(SEP_WIDTH)
+	for i, collision := range collisions {
+		if i == maxSizes {
+			fmt.Printf("... and %d more sizes\n", len(collisions)-maxSizes)
+			break
+		}
+		fmt.Printf("  %-14d %-10s files: %-8d distinct contents: %d\n",
+			collision.Filesize, utils.RepresentBytes(collision.Filesize), collision.NumFiles, collision.NumHashes)
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// FilesOfSizeReport prints the catalogued files with the provided size, sorted,
+// whatever their hash: the files behind a line of SizeCollisionsReport.
+func FilesOfSizeReport(hashMap map[utils.HashPair][]string, size int64) {
+	paths := config.PathsBySize(hashMap, size)
+	sort.Strings(paths)
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Printf("FILES OF SIZE %d (%s)\n", size, utils.RepresentBytes(size))
+	fmt.Printf("\tFILES:\t\t%d\n", len(paths))
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, path := range paths {
+		fmt.Printf("  %s\n", utils.EscapeControl(path))
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// hashModes are the quick and full hashes of a file
+type hashModes struct {
+	quick string
+	full  string
+}
+
+// hashBothModes computes the quick (-u) and the full (-U) hash of path, the
+// quick one with the tiny multiplier of the database.
+func hashBothModes(path string, filesize int64, tinyMultiplier int64, quickEngine, fullEngine hash.Hash) (hashModes, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return hashModes{}, err
+	}
+	defer file.Close()
+	var modes hashModes
+	if modes.quick, err = utils.QuickHashGen(quickEngine, file, quickHashArea, filesize, tinyMultiplier); err != nil {
+		return hashModes{}, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return hashModes{}, err
+	}
+	if modes.full, err = utils.HashGen(fullEngine, file); err != nil {
+		return hashModes{}, err
+	}
+	return modes, nil
+}
+
+// CompareHashModesReport hashes with both the quick and the full hash the files
+// of the maxSizes biggest filesizes shared by more files in the database, and
+// reports how many groups the quick hash merges that the full hash splits.
+// The quick hash is the one of the database (metadata nil means the default),
+// files up to its tiny multiplier of quick hash areas are always fully hashed,
+// so the biggest sizes are the informative ones.
+func CompareHashModesReport(hashMap map[utils.HashPair][]string, maxSizes int, metadata *config.Metadata) {
+	bySize := make(map[int64][]string)
+	for hashPair, paths := range hashMap {
+		bySize[hashPair.Filesize] = append(bySize[hashPair.Filesize], paths...)
+	}
+	var sizes []int64
+	for filesize, paths := range bySize {
+		if filesize > 0 && len(paths) > 1 {
+			sizes = append(sizes, filesize)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+	if len(sizes) > maxSizes {
+		sizes = sizes[:maxSizes]
+	}
+
+	quickEngine, fullEngine := md5.New(), md5.New()
+	var numFiles, numFailed, numQuickGroups, numSplitGroups int
+	for _, filesize := range sizes {
+		slog.Debug("comparing hash modes", "filesize", filesize, "files", len(bySize[filesize]))
+		fullByQuick := make(map[string]map[string]int) // quick hash -> full hash -> files
+		for _, path := range bySize[filesize] {
+			modes, err := hashBothModes(path, filesize, metadata.QuickTinyMultiplier(), quickEngine, fullEngine)
+			if err != nil {
+				slog.Warn("failed to hash file", "path", path, "err", err)
+				numFailed++
+				continue
+			}
+			numFiles++
+			if fullByQuick[modes.quick] == nil {
+				fullByQuick[modes.quick] = make(map[string]int)
+			}
+			fullByQuick[modes.quick][modes.full]++
+		}
+		for _, fullHashes := range fullByQuick {
+			groupFiles := 0
+			for _, n := range fullHashes {
+				groupFiles += n
+			}
+			if groupFiles < 2 {
+				continue
+			}
+			numQuickGroups++
+			if len(fullHashes) > 1 {
+				numSplitGroups++
+			}
+		}
+	}
+
+	agreement := float32(100)
+	if numQuickGroups > 0 {
+		agreement = float32(numQuickGroups-numSplitGroups) * 100 / float32(numQuickGroups)
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("QUICK (-u) VS FULL (-U) HASH")
+	fmt.Printf("\tSIZES:\t\t%-20dFILES: %d (%d unreadable)\n", len(sizes), numFiles, numFailed)
+	fmt.Printf("\tQUICK GROUPS:\t%-20dSPLIT BY FULL HASH: %d\n", numQuickGroups, numSplitGroups)
+	fmt.Printf("\tAGREEMENT:\t%.1f%%\n", agreement)
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// dirContent is the catalogued content of a folder, its direct files only
+type dirContent struct {
+	entries []string // "filename\x00size\x00hash" of each file
+	size    int64
+	unique  bool // holds a file with a unique size, no other folder can match
+}
+
+// DuplicateDirsReport prints the groups of folders holding the same files, by
+// name and content, according to the database. Only the direct files of each
+// folder are compared, subfolders are compared on their own.
+func DuplicateDirsReport(hashMap map[utils.HashPair][]string) {
+	dirs := make(map[string]*dirContent)
+	for hashPair, paths := range hashMap {
+		for _, path := range paths {
+			dir := filepath.Dir(path)
+			content, ok := dirs[dir]
+			if !ok {
+				content = &dirContent{}
+				dirs[dir] = content
+			}
+			content.entries = append(content.entries,
+				fmt.Sprintf("%s\x00%d\x00%s", filepath.Base(path), hashPair.Filesize, hashPair.Hash))
+			content.size += hashPair.Filesize
+			content.unique = content.unique || hashPair.Hash == "" || utils.IsPartialHash(hashPair.Hash)
+		}
+	}
+
+	byIdentity := make(map[string][]string)
+	for dir, content := range dirs {
+		if content.unique {
+			continue
+		}
+		sort.Strings(content.entries)
+		sum := md5.Sum([]byte(strings.Join(content.entries, "\n")))
+		identity := hex.EncodeToString(sum[:])
+		byIdentity[identity] = append(byIdentity[identity], dir)
+	}
+	var groups [][]string
+	var numDirs int
+	var wasted int64
+	for _, group := range byIdentity {
+		if len(group) > 1 {
+			sort.Strings(group)
+			groups = append(groups, group)
+			numDirs += len(group)
+			wasted += dirs[group[0]].size * int64(len(group)-1)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		sizeI, sizeJ := dirs[groups[i][0]].size, dirs[groups[j][0]].size
+		if sizeI != sizeJ {
+			return sizeI > sizeJ
+		}
+		return groups[i][0] < groups[j][0]
+	})
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("DUPLICATE FOLDERS (same file names and contents)")
+	fmt.Printf("\tGROUPS:\t\t%-20dFOLDERS: %d  DUP_SIZE: %s\n", len(groups), numDirs, utils.RepresentBytes(wasted))
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, group := range groups {
+		content := dirs[group[0]]
+		fmt.Printf("  %d files, %s\n", len(content.entries), utils.RepresentBytes(content.size))
+		for _, dir := range group {
+			fmt.Printf("%s- %s\n", indent, utils.EscapeControl(dir))
+		}
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// CaseCollisionsReport prints the groups of catalogued files whose paths differ
+// only by letter case (e.g. Photo.JPG and photo.jpg), they collide when copied
+// or restored to a case-insensitive filesystem. Each group tells if the files
+// have the same content according to the database.
+func CaseCollisionsReport(hashMap map[utils.HashPair][]string) {
+	byFolded := make(map[string][]string)
+	hashByPath := make(map[string]utils.HashPair)
+	config.EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		folded := strings.ToLower(path)
+		byFolded[folded] = append(byFolded[folded], path)
+		hashByPath[path] = hashPair
+	})
+
+	var groups [][]string
+	var numFiles int
+	for _, group := range byFolded {
+		if len(group) > 1 {
+			sort.Strings(group)
+			groups = append(groups, group)
+			numFiles += len(group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("CASE COLLISIONS (paths differing only by case)")
+	fmt.Printf("\tGROUPS:\t\t%-20dFILES: %d\n", len(groups), numFiles)
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, group := range groups {
+		//files with a unique size (no hash) cannot have the same content
+		first := hashByPath[group[0]]
+		same := first.Hash != ""
+		for _, path := range group[1:] {
+			same = same && hashByPath[path] == first
+		}
+		if same {
+			fmt.Printf("  %d files, same content\n", len(group))
+		} else {
+			fmt.Printf("  %d files, DIFFERENT content\n", len(group))
+		}
+		for _, path := range group {
+			fmt.Printf("%s- %s\n", indent, utils.EscapeControl(path))
+		}
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// DatabaseStatsReport prints the overall statistics of the database alone, the
+// ones a listing of every catalogued file would end with, without reading the
+// filesystem. Empty files, files never hashed but sharing their size and
+// partially hashed files are ignored as the listing does.
+func DatabaseStatsReport(hashMap map[utils.HashPair][]string) {
+	var stats counters.Stats
+	for hashPair, paths := range hashMap {
+		switch {
+		case hashPair.Filesize == 0 || (hashPair.Hash == "" && len(paths) > 1) || utils.IsPartialHash(hashPair.Hash):
+			for range paths {
+				stats.AddIgnoredFile(hashPair.Filesize)
+			}
+		case len(paths) == 1:
+			stats.AddUniqueFile(hashPair.Filesize)
+		default:
+			for range paths {
+				stats.AddDupFile(hashPair.Filesize)
+			}
+			stats.AddReclaimable(int64(len(paths)-1) * hashPair.Filesize)
+		}
+	}
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("DATABASE STATS")
+	fmt.Print(stats.StringSummary())
+	fmt.Printf("\tRECLAIMABLE:\t%-9s [%5.1f%%]\n", utils.RepresentBytes(stats.SizeReclaimable), stats.ReclaimPerc())
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// LargestFilesReport prints the maxFiles biggest files of the database under
+// roots (all the database when roots is empty), duplicate or not, with their
+// number of copies.
+func LargestFilesReport(hashMap map[utils.HashPair][]string, roots []string, maxFiles int) {
+	type largeFile struct {
+		path     string
+		hashPair utils.HashPair
+	}
+	var files []largeFile
+	config.EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		if len(roots) == 0 || utils.IsUnderAny(path, roots) {
+			files = append(files, largeFile{path: path, hashPair: hashPair})
+		}
+	})
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].hashPair.Filesize != files[j].hashPair.Filesize {
+			return files[i].hashPair.Filesize > files[j].hashPair.Filesize
+		}
+		return files[i].path < files[j].path
+	})
+	if len(files) > maxFiles {
+		files = files[:maxFiles]
+	}
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Printf("LARGEST %d FILES\n", len(files))
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, file := range files {
+		copies := len(hashMap[file.hashPair])
+		if file.hashPair.Hash == "" {
+			copies = 1 //never hashed, the copies are unknown
+		}
+		fmt.Printf("  %-10s copies: %-4d %s\n",
+			utils.RepresentBytes(file.hashPair.Filesize), copies, utils.EscapeControl(file.path))
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// maxPairDirs is the most folders a duplicate group may span to be counted by
+// DirPairsReport
+const maxPairDirs = 100
+
+// dirPair counts the duplicate contents two folders share
+type dirPair struct {
+	dirA      string
+	dirB      string
+	numShared int   // contents with a copy in both folders
+	size      int64 // bytes of the shared contents, counted once
+	filesA    int   // files of dirA whose content is in dirB
+	filesB    int
+}
+
+// DirPairsReport prints the maxPairs pairs of folders sharing the most
+// duplicate bytes in the database, with the number of shared contents and the
+// share of the files of each folder having a copy in the other: it surfaces backup folders
+// that largely overlap. Subfolders are separate folders. A group with copies in
+// more than maxPairDirs folders is skipped (it alone would add up to
+// maxPairDirs² pairs), the skipped groups are counted in the report.
+func DirPairsReport(hashMap map[utils.HashPair][]string, maxPairs int) {
+	numFiles := make(map[string]int) //files of each folder in the database
+	config.EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		numFiles[filepath.Dir(path)]++
+	})
+	pairs := make(map[[2]string]*dirPair)
+	skipped := 0
+	for _, hashPair := range duplicateGroups(hashMap, nil) {
+		copiesIn := make(map[string]int) //folder -> copies of the group
+		var dirs []string
+		for _, path := range hashMap[hashPair] {
+			dir := filepath.Dir(path)
+			if copiesIn[dir] == 0 {
+				dirs = append(dirs, dir)
+			}
+			copiesIn[dir]++
+		}
+		if len(dirs) > maxPairDirs {
+			skipped++
+			continue
+		}
+		sort.Strings(dirs)
+		for i := range dirs {
+			for _, dirB := range dirs[i+1:] {
+				key := [2]string{dirs[i], dirB}
+				if pairs[key] == nil {
+					pairs[key] = &dirPair{dirA: dirs[i], dirB: dirB}
+				}
+				pairs[key].numShared++
+				pairs[key].size += hashPair.Filesize
+				pairs[key].filesA += copiesIn[dirs[i]]
+				pairs[key].filesB += copiesIn[dirB]
+			}
+		}
+	}
+	sorted := make([]*dirPair, 0, len(pairs))
+	for _, pair := range pairs {
+		sorted = append(sorted, pair)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].size != sorted[j].size {
+			return sorted[i].size > sorted[j].size
+		}
+		if sorted[i].dirA != sorted[j].dirA {
+			return sorted[i].dirA < sorted[j].dirA
+		}
+		return sorted[i].dirB < sorted[j].dirB
+	})
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("FOLDER PAIRS SHARING DUPLICATES")
+	fmt.Printf("\tPAIRS:\t\t%d\n", len(sorted))
+	if skipped > 0 {
+		fmt.Printf("\tSKIPPED:\t%d groups with copies in more than %d folders\n", skipped, maxPairDirs)
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+	for i, pair := range sorted {
+		if i == maxPairs {
+			fmt.Printf("... and %d more pairs\n", len(sorted)-maxPairs)
+			break
+		}
+		fmt.Printf("  %d shared contents, %s\n", pair.numShared, utils.RepresentBytes(pair.size))
+		fmt.Printf("%s- %s [%5.1f%% of its files]\n", indent, utils.EscapeControl(pair.dirA),
+			100*float64(pair.filesA)/float64(numFiles[pair.dirA]))
+		fmt.Printf("%s- %s [%5.1f%% of its files]\n", indent, utils.EscapeControl(pair.dirB),
+			100*float64(pair.filesB)/float64(numFiles[pair.dirB]))
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// ContainingReport prints every duplicate group of the database having a copy
+// under roots, with all its copies: those elsewhere are marked, they show where
+// the files under roots have been copied to (backups, leaked copies).
+func ContainingReport(hashMap map[utils.HashPair][]string, roots []string) {
+	groups := duplicateGroups(hashMap, roots)
+	var numElsewhere int
+	for _, hashPair := range groups {
+		for _, path := range hashMap[hashPair] {
+			if !utils.IsUnderAny(path, roots) {
+				numElsewhere++
+			}
+		}
+	}
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Printf("DUPLICATE GROUPS CONTAINING %s\n", utils.EscapeControl(strings.Join(roots, ", ")))
+	fmt.Printf("\tGROUPS:\t\t%-20dCOPIES ELSEWHERE: %d\n", len(groups), numElsewhere)
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, hashPair := range groups {
+		paths := append([]string(nil), hashMap[hashPair]...)
+		sort.Strings(paths)
+		fmt.Printf("  %d copies of %s\n", len(paths), utils.RepresentBytes(hashPair.Filesize))
+		for _, path := range paths {
+			if utils.IsUnderAny(path, roots) {
+				fmt.Printf("%s- %s\n", indent, utils.EscapeControl(path))
+			} else {
+				fmt.Printf("%s- %s  (elsewhere)\n", indent, utils.EscapeControl(path))
+			}
+		}
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// DiffDatabasesReport prints the files added, removed and changed between two
+// snapshots of the database, joined by path. A file is changed when its size
+// differs, or when both snapshots have its hash and they differ (files with a
+// unique size are not hashed, so a content change of the same size is only
+// seen when both are hashed).
+func DiffDatabasesReport(oldMap map[utils.HashPair][]string, newMap map[utils.HashPair][]string) {
+	oldFiles := config.InvertMap(oldMap)
+	newFiles := config.InvertMap(newMap)
+	var added, removed, changed []string
+	for path, newPair := range newFiles {
+		oldPair, ok := oldFiles[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case oldPair.Filesize != newPair.Filesize,
+			oldPair.Hash != "" && newPair.Hash != "" && oldPair.Hash != newPair.Hash:
+			changed = append(changed, path)
+		}
+	}
+	for path := range oldFiles {
+		if _, ok := newFiles[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("DATABASE DIFF")
+	fmt.Printf("\tADDED:\t\t%-20dREMOVED: %d  CHANGED: %d\n", len(added), len(removed), len(changed))
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, section := range []struct {
+		label string
+		paths []string
+	}{{"ADDED", added}, {"REMOVED", removed}, {"CHANGED", changed}} {
+		if len(section.paths) == 0 {
+			continue
+		}
+		sort.Strings(section.paths)
+		fmt.Printf("  %s (%d)\n", section.label, len(section.paths))
+		for _, path := range section.paths {
+			fmt.Printf("%s- %s\n", indent, utils.EscapeControl(path))
+		}
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// similarPrefixLen is the number of leading bytes compared by --size-tolerance
+const similarPrefixLen int64 = 4096
+
+// similarFile is a candidate of the --size-tolerance report
+type similarFile struct {
+	path   string
+	size   int64
+	prefix string // hash of the first similarPrefixLen bytes
+}
+
+// withinTolerance tells if size is at most tolerance percent bigger than base
+func withinTolerance(base int64, size int64, tolerance float64) bool {
+	return float64(size-base)*100 <= tolerance*float64(base)
+}
+
+// SimilarFilesReport prints the groups of database files under roots (all when
+// roots is empty) whose sizes differ by at most tolerance percent and whose
+// first 4096 bytes are the same, e.g. revisions of a document or rotated logs. It is
+// a fuzzy match, the files of a group are not duplicates. Smaller files are
+// not compared.
+func SimilarFilesReport(hashMap map[utils.HashPair][]string, roots []string, tolerance float64) {
+	var files []similarFile
+	config.EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		if hashPair.Filesize >= similarPrefixLen && (len(roots) == 0 || utils.IsUnderAny(path, roots)) {
+			files = append(files, similarFile{path: path, size: hashPair.Filesize})
+		}
+	})
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].size != files[j].size {
+			return files[i].size < files[j].size
+		}
+		return files[i].path < files[j].path
+	})
+
+	//only the files with a size neighbour within the tolerance are read
+	prefixEngine := md5.New()
+	byPrefix := make(map[string][]similarFile)
+	for i := range files {
+		near := (i > 0 && withinTolerance(files[i-1].size, files[i].size, tolerance)) ||
+			(i < len(files)-1 && withinTolerance(files[i].size, files[i+1].size, tolerance))
+		if !near {
+			continue
+		}
+		file, err := os.Open(files[i].path)
+		if err == nil {
+			files[i].prefix, err = utils.HashGen(prefixEngine, io.LimitReader(file, similarPrefixLen))
+			file.Close()
+		}
+		if err != nil {
+			slog.Warn("failed to read file", "path", files[i].path, "err", err)
+			continue
+		}
+		byPrefix[files[i].prefix] = append(byPrefix[files[i].prefix], files[i])
+	}
+
+	//files sorted by size, a group grows while within the tolerance of its smallest file
+	var groups [][]similarFile
+	for _, candidates := range byPrefix {
+		start := 0
+		for i := 1; i <= len(candidates); i++ {
+			if i < len(candidates) && withinTolerance(candidates[start].size, candidates[i].size, tolerance) {
+				continue
+			}
+			group := candidates[start:i]
+			if len(group) > 1 && group[0].size != group[len(group)-1].size {
+				groups = append(groups, group)
+			}
+			start = i
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].path < groups[j][0].path })
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Printf("SIMILAR FILES (fuzzy: sizes within %g%%, same first %d bytes, NOT duplicates)\n",
+		tolerance, similarPrefixLen)
+	fmt.Printf("\tGROUPS:\t\t%d\n", len(groups))
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, group := range groups {
+		fmt.Printf("  %d files, %s to %s\n", len(group),
+			utils.RepresentBytes(group[0].size), utils.RepresentBytes(group[len(group)-1].size))
+		for _, file := range group {
+			fmt.Printf("%s- %-10s %s\n", indent, utils.RepresentBytes(file.size), utils.EscapeControl(file.path))
+		}
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// truncatedFile is a possibly truncated copy of a bigger file
+type truncatedFile struct {
+	path     string
+	size     int64
+	complete similarFile // the bigger file it is a prefix of
+}
+
+// TruncatedFilesReport prints the database files under roots (all when roots
+// is empty) whose whole content is the beginning of a bigger file under roots,
+// e.g. remnants of interrupted downloads or copies. Candidates share the hash
+// of the first 4096 bytes and are then compared byte by byte. Smaller files
+// are not compared.
+func TruncatedFilesReport(hashMap map[utils.HashPair][]string, roots []string) {
+	prefixEngine := md5.New()
+	byPrefix := make(map[string][]similarFile)
+	config.EachFile(hashMap, func(path string, hashPair utils.HashPair) {
+		if hashPair.Filesize < similarPrefixLen || (len(roots) > 0 && !utils.IsUnderAny(path, roots)) {
+			return
+		}
+		file, err := os.Open(path)
+		var prefix string
+		if err == nil {
+			prefix, err = utils.HashGen(prefixEngine, io.LimitReader(file, similarPrefixLen))
+			file.Close()
+		}
+		if err != nil {
+			slog.Warn("failed to read file", "path", path, "err", err)
+			return
+		}
+		byPrefix[prefix] = append(byPrefix[prefix], similarFile{path: path, size: hashPair.Filesize, prefix: prefix})
+	})
+
+	var truncated []truncatedFile
+	for _, candidates := range byPrefix {
+		//biggest first, each file is compared with the bigger ones until one contains it
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].size != candidates[j].size {
+				return candidates[i].size > candidates[j].size
+			}
+			return candidates[i].path < candidates[j].path
+		})
+		for i, short := range candidates {
+			for _, long := range candidates[:i] {
+				if long.size == short.size {
+					continue //same size, a duplicate or a different file, not a truncation
+				}
+				if isPrefixOf(short.path, long.path, short.size) {
+					truncated = append(truncated, truncatedFile{path: short.path, size: short.size, complete: long})
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(truncated, func(i, j int) bool { return truncated[i].path < truncated[j].path })
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("POSSIBLY TRUNCATED FILES (whole content is the beginning of a bigger file)")
+	fmt.Printf("\tFILES:\t\t%d\n", len(truncated))
+	utils.PrintSeparator(SEP_WIDTH)
+	for _, file := range truncated {
+		fmt.Printf("  %s (%s, %.0f%%)\n%sprefix of %s (%s)\n",
+			utils.EscapeControl(file.path), utils.RepresentBytes(file.size),
+			float64(file.size)*100/float64(file.complete.size),
+			indent, utils.EscapeControl(file.complete.path), utils.RepresentBytes(file.complete.size))
+	}
+	utils.PrintSeparator(SEP_WIDTH)
+}
+
+// isPrefixOf tells if the content of shortPath, of shortSize bytes, is the
+// beginning of longPath.
+func isPrefixOf(shortPath string, longPath string, shortSize int64) bool {
+	short, err := os.Open(shortPath)
+	if err != nil {
+		return false
+	}
+	defer short.Close()
+	long, err := os.Open(longPath)
+	if err != nil {
+		return false
+	}
+	defer long.Close()
+	offset, _, err := utils.FirstDiffOffset(short, long)
+	return err == nil && offset == shortSize
+}
@@ -0,0 +1,178 @@
+package workflow
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	cfg "github.com/ftarlao/duplito/config"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// Resume is the state of an interrupted update: where it stopped and the
+// database it saved. Folders up to the checkpoint are not hashed again.
+type Resume struct {
+	Checkpoint cfg.Checkpoint
+	HashMap    map[utils.HashPair][]string
+}
+
+// walkOrderLess compares two clean paths in the order used by HybridWalk:
+// element by element, so that a folder comes before its subfolders and
+// siblings are sorted by name.
+func walkOrderLess(a string, b string) bool {
+	partsA := strings.Split(a, string(filepath.Separator))
+	partsB := strings.Split(b, string(filepath.Separator))
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		if partsA[i] != partsB[i] {
+			return partsA[i] < partsB[i]
+		}
+	}
+	return len(partsA) < len(partsB)
+}
+
+// rootIndex returns the index of the scanned root that contains path, or -1.
+func rootIndex(absRoots []string, path string) int {
+	for i, root := range absRoots {
+		if utils.IsUnder(path, root) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isDone tells if the folder dir, under the root with index rootIdx, was
+// completely walked before the checkpoint.
+func (r *Resume) isDone(absRoots []string, rootIdx int, dir string) bool {
+	if r == nil || rootIdx < 0 {
+		return false
+	}
+	cpIdx := rootIndex(absRoots, r.Checkpoint.Root)
+	switch {
+	case rootIdx < cpIdx:
+		return true
+	case rootIdx > cpIdx:
+		return false
+	}
+	return r.Checkpoint.Folder != "" && !walkOrderLess(r.Checkpoint.Folder, dir)
+}
+
+// keptFiles returns the catalogued files of the folders already done, they
+// are kept as they are in the new database.
+func (r *Resume) keptFiles(absRoots []string) map[string]utils.HashPair {
+	kept := make(map[string]utils.HashPair)
+	if r == nil {
+		return kept
+	}
+	for hashPair, paths := range r.HashMap {
+		for _, path := range paths {
+			if r.isDone(absRoots, rootIndex(absRoots, path), filepath.Dir(path)) {
+				kept[path] = hashPair
+			}
+		}
+	}
+	return kept
+}
+
+// checkpointTracker builds the checkpoint of an update from the folders whose
+// files were all collected: a folder completely walked is done only once the
+// results of its files reach the collector, and a folder with a failed file
+// stops the checkpoint, so that queued, in flight and failed files are hashed
+// again on resume. The walk and the collector use it concurrently, a nil
+// folder (promoted or kept files) is not tracked.
+type checkpointTracker struct {
+	mu      sync.Mutex
+	folders []*trackedFolder //walked folders not done yet, in walk order
+	current *trackedFolder   //folder being walked
+	done    cfg.Checkpoint   //last folder done, with all the previous ones
+	blocked bool             //a folder failed, the checkpoint does not advance further
+}
+
+// trackedFolder is a folder of the walk with its files not collected yet
+type trackedFolder struct {
+	root    string
+	path    string
+	pending int  //files sent by the walk, results not collected yet
+	walked  bool //every file of the folder was sent
+	failed  bool //a file of the folder could not be read or hashed
+}
+
+// enter starts the folder dir of root, the previous folder is completely walked.
+func (t *checkpointTracker) enter(root string, dir string) *trackedFolder {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != nil {
+		t.current.walked = true
+	}
+	t.current = &trackedFolder{root: root, path: dir}
+	t.folders = append(t.folders, t.current)
+	t.advance()
+	return t.current
+}
+
+// close marks folder, the last of a root, completely walked.
+func (t *checkpointTracker) close(folder *trackedFolder) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	folder.walked = true
+	t.current = nil
+	t.advance()
+}
+
+// sent records a file of folder sent to the workers or to the collector.
+func (t *checkpointTracker) sent(folder *trackedFolder) {
+	if folder == nil {
+		return
+	}
+	t.mu.Lock()
+	folder.pending++
+	t.mu.Unlock()
+}
+
+// fail records a file of folder that could not be read.
+func (t *checkpointTracker) fail(folder *trackedFolder) {
+	if folder == nil {
+		return
+	}
+	t.mu.Lock()
+	folder.failed = true
+	t.mu.Unlock()
+}
+
+// collected records the result of a file of folder, failed or not.
+func (t *checkpointTracker) collected(folder *trackedFolder, failed bool) {
+	if folder == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	folder.pending--
+	folder.failed = folder.failed || failed
+	t.advance()
+}
+
+// advance moves the checkpoint over the folders done, the lock is held.
+func (t *checkpointTracker) advance() {
+	for len(t.folders) > 0 && !t.blocked {
+		folder := t.folders[0]
+		if !folder.walked || folder.pending > 0 {
+			return
+		}
+		if folder.failed {
+			t.blocked = true
+			return
+		}
+		t.done = cfg.Checkpoint{Root: folder.root, Folder: folder.path}
+		t.folders = t.folders[1:]
+	}
+}
+
+// checkpoint returns where to resume from, firstRoot with no folder done when
+// no folder was completed.
+func (t *checkpointTracker) checkpoint(firstRoot string) cfg.Checkpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done.Root == "" {
+		return cfg.Checkpoint{Root: firstRoot}
+	}
+	return t.done
+}
@@ -0,0 +1,136 @@
+package workflow
+
+import (
+	"sync"
+	"testing"
+
+	cfg "github.com/ftarlao/duplito/config"
+)
+
+// The walk order puts a folder before its subfolders, whatever the characters
+// sorting before the separator.
+func TestWalkOrderLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/a", "/a/b", true},
+		{"/a/b", "/a", false},
+		{"/a/b", "/a-b", true},
+		{"/a-b", "/a/b", false},
+		{"/a/z", "/a/b/c", false},
+		{"/a/b/c", "/a/z", true},
+		{"/a/b", "/a/b", false},
+	}
+	for _, tt := range tests {
+		if got := walkOrderLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("walkOrderLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// trackedStep is a folder walked in a checkpointTracker test
+type trackedStep struct {
+	path       string
+	files      int
+	walkFailed bool //the walk could not read a file of the folder
+	hashFailed bool //a result of the folder is a failure
+	pending    bool //the results of the folder are never collected
+}
+
+// The checkpoint stops before the first folder failed or with results not
+// collected, whatever the order the results are collected in.
+func TestCheckpointTracker(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []trackedStep
+		want  cfg.Checkpoint
+	}{
+		{
+			name:  "all collected",
+			steps: []trackedStep{{path: "/r", files: 2}, {path: "/r/a", files: 1}, {path: "/r/b"}},
+			want:  cfg.Checkpoint{Root: "/r", Folder: "/r/b"},
+		},
+		{
+			name:  "failed folder blocks",
+			steps: []trackedStep{{path: "/r", files: 2}, {path: "/r/a", files: 2, hashFailed: true}, {path: "/r/b", files: 1}},
+			want:  cfg.Checkpoint{Root: "/r", Folder: "/r"},
+		},
+		{
+			name:  "walk error blocks",
+			steps: []trackedStep{{path: "/r", files: 1}, {path: "/r/a", files: 1}, {path: "/r/b", walkFailed: true}},
+			want:  cfg.Checkpoint{Root: "/r", Folder: "/r/a"},
+		},
+		{
+			name:  "first folder failed",
+			steps: []trackedStep{{path: "/r", files: 1, hashFailed: true}, {path: "/r/a", files: 1}},
+			want:  cfg.Checkpoint{Root: "/r"},
+		},
+		{
+			name:  "results in flight",
+			steps: []trackedStep{{path: "/r", files: 1}, {path: "/r/a", files: 3, pending: true}, {path: "/r/b", files: 1}},
+			want:  cfg.Checkpoint{Root: "/r", Folder: "/r"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &checkpointTracker{}
+			var folders []*trackedFolder
+			var folder *trackedFolder
+			for _, step := range tt.steps {
+				folder = tracker.enter("/r", step.path)
+				folders = append(folders, folder)
+				for i := 0; i < step.files; i++ {
+					tracker.sent(folder)
+				}
+				if step.walkFailed {
+					tracker.fail(folder)
+				}
+			}
+			tracker.close(folder)
+			for i := len(tt.steps) - 1; i >= 0; i-- {
+				step := tt.steps[i]
+				for j := 0; j < step.files && !step.pending; j++ {
+					tracker.collected(folders[i], step.hashFailed && j == 0)
+				}
+			}
+			if got := tracker.checkpoint("/r"); got != tt.want {
+				t.Errorf("checkpoint %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The results collected while the walk goes on, as the update does, move the
+// checkpoint to the last folder once all are collected.
+func TestCheckpointTrackerConcurrent(t *testing.T) {
+	tracker := &checkpointTracker{}
+	results := make(chan *trackedFolder, 16)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for folder := range results {
+				tracker.collected(folder, false)
+			}
+		}()
+	}
+	paths := []string{"/r", "/r/a", "/r/a/b", "/r/c", "/r/d"}
+	var folder *trackedFolder
+	for _, path := range paths {
+		folder = tracker.enter("/r", path)
+		for i := 0; i < 100; i++ {
+			tracker.sent(folder)
+			results <- folder
+		}
+	}
+	tracker.close(folder)
+	close(results)
+	wg.Wait()
+
+	want := cfg.Checkpoint{Root: "/r", Folder: "/r/d"}
+	if got := tracker.checkpoint("/r"); got != want {
+		t.Errorf("checkpoint %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// rmlintHeader is the first element of an rmlint json-dump
+type rmlintHeader struct {
+	Description  string   `json:"description"`
+	Cwd          string   `json:"cwd"`
+	Args         string   `json:"args"`
+	Version      string   `json:"version"`
+	Rev          string   `json:"rev"`
+	Progress     int      `json:"progress"`
+	ChecksumType string   `json:"checksum_type"`
+	Roots        []string `json:"duplito_roots,omitempty"`
+	FullContent  bool     `json:"duplito_full_content"` //false: the checksums sample the files (-u), check before deleting
+}
+
+// rmlintFile is a duplicate file element of an rmlint json-dump
+type rmlintFile struct {
+	ID         int     `json:"id"`
+	Type       string  `json:"type"`
+	Progress   int     `json:"progress"`
+	Checksum   string  `json:"checksum"`
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`
+	Depth      int     `json:"depth"`
+	Inode      uint64  `json:"inode"`
+	DiskID     uint64  `json:"disk_id"`
+	IsOriginal bool    `json:"is_original"`
+	Twins      int     `json:"twins"`
+	Mtime      float64 `json:"mtime"`
+}
+
+// rmlintFooter is the last element of an rmlint json-dump
+type rmlintFooter struct {
+	Aborted       bool  `json:"aborted"`
+	Progress      int   `json:"progress"`
+	TotalFiles    int   `json:"total_files"`
+	IgnoredFiles  int   `json:"ignored_files"`
+	IgnoredFolder int   `json:"ignored_folders"`
+	Duplicates    int   `json:"duplicates"`
+	DuplicateSets int   `json:"duplicate_sets"`
+	TotalLintSize int64 `json:"total_lint_size"`
+}
+
+// WriteRmlintJSON writes the duplicate groups of the database having a copy
+// under roots (all the groups when roots is empty) as an rmlint json-dump
+// (--rmlint-json): a JSON array with a header, a "duplicate_file" element for
+// each copy and a footer. In each group the kept copy of --gen-script comes
+// first with is_original true. The checksum is the database hash, md5 of the
+// sampled content for a quick hash (-u) database: fullContent false is recorded
+// in the header. Copies missing on disk or whose size changed since the last
+// update are left out. Returns the number of groups.
+func WriteRmlintJSON(w io.Writer, hashMap map[utils.HashPair][]string, roots []string, keepUnder []string,
+	args []string, fullContent bool) (int, error) {
+	bw := bufio.NewWriter(w)
+	numElements := 0
+	writeElement := func(element any) {
+		if numElements > 0 {
+			bw.WriteString(",\n")
+		}
+		line, _ := json.Marshal(element) //plain structs, cannot fail
+		bw.Write(line)
+		numElements++
+	}
+	cwd, _ := os.Getwd()
+	bw.WriteString("[\n")
+	writeElement(rmlintHeader{Description: "rmlint json-dump of lint files", Cwd: cwd,
+		Args: strings.Join(args, " "), Version: "duplito", ChecksumType: "md5", Roots: roots, FullContent: fullContent})
+
+	var footer rmlintFooter
+	for _, hashPair := range duplicateGroups(hashMap, roots) {
+		var files []rmlintFile
+		for _, path := range hashMap[hashPair] {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() || info.Size() != hashPair.Filesize {
+				continue //changed since the last update, the checksum is stale
+			}
+			id, _, _ := utils.FileIdentity(info)
+			files = append(files, rmlintFile{Type: "duplicate_file", Checksum: hashPair.Hash, Path: path,
+				Size: hashPair.Filesize, Depth: strings.Count(filepath.Clean(path), string(filepath.Separator)),
+				Inode: id.Ino, DiskID: id.Dev, Mtime: float64(info.ModTime().UnixNano()) / 1e9})
+		}
+		if len(files) < 2 {
+			continue
+		}
+		paths := make([]string, len(files))
+		for i := range files {
+			paths[i] = files[i].Path
+		}
+		keeper := keeperOf(paths, keepUnder)
+		sort.Slice(files, func(i, j int) bool {
+			if (files[i].Path == keeper) != (files[j].Path == keeper) {
+				return files[i].Path == keeper
+			}
+			return files[i].Path < files[j].Path
+		})
+		footer.DuplicateSets++
+		for i := range files {
+			footer.TotalFiles++
+			files[i].ID = footer.TotalFiles
+			files[i].Twins = len(files)
+			files[i].IsOriginal = i == 0
+			if i > 0 {
+				footer.Duplicates++
+				footer.TotalLintSize += files[i].Size
+			}
+			writeElement(files[i])
+		}
+	}
+	footer.Progress = 100
+	writeElement(footer)
+	bw.WriteString("\n]\n")
+	return footer.DuplicateSets, bw.Flush()
+}
@@ -0,0 +1,220 @@
+package workflow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	config "github.com/ftarlao/duplito/config"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// shellQuote quotes s for a POSIX shell, single quotes included.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// keeperOf returns the copy kept by the cleanup script, the first by path order
+// among the copies under keepUnder (--keep-under) when there are any.
+func keeperOf(group []string, keepUnder []string) string {
+	keeper := group[0]
+	keeperProtected := utils.IsUnderAny(keeper, keepUnder)
+	for _, member := range group[1:] {
+		protected := utils.IsUnderAny(member, keepUnder)
+		if (protected && !keeperProtected) || (protected == keeperProtected && member < keeper) {
+			keeper, keeperProtected = member, protected
+		}
+	}
+	return keeper
+}
+
+// presentCopy tells if path is still a regular file with the size recorded in
+// the database, copies deleted or rewritten since the last update are never
+// acted on.
+func presentCopy(path string, filesize int64) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == filesize
+}
+
+// duplicateGroups returns the hashed groups of the database with more copies,
+// at least one under roots (all of them when roots is empty), the groups with
+// the most reclaimable bytes first. Partial hashes (--resume-hash) do not
+// tell the content, their groups are left out.
+func duplicateGroups(hashMap map[utils.HashPair][]string, roots []string) []utils.HashPair {
+	var groups []utils.HashPair
+	for hashPair, paths := range hashMap {
+		if hashPair.Hash == "" || utils.IsPartialHash(hashPair.Hash) || len(paths) < 2 {
+			continue
+		}
+		if len(roots) > 0 && !anyUnder(paths, roots) {
+			continue
+		}
+		groups = append(groups, hashPair)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		sizeI := groups[i].Filesize * int64(len(hashMap[groups[i]])-1)
+		sizeJ := groups[j].Filesize * int64(len(hashMap[groups[j]])-1)
+		if sizeI != sizeJ {
+			return sizeI > sizeJ
+		}
+		return groups[i].Hash < groups[j].Hash
+	})
+	return groups
+}
+
+// WriteCleanupScript writes a shell script with a commented block for each
+// duplicate group of the database having a copy under roots (all the groups
+// when roots is empty). The first copy by path order is kept, for each other
+// copy a commented rm line and a commented ln line (replace with a hardlink)
+// are written, the user uncomments what to run. Copies under keepUnder are
+// kept, the first of them when there are any. Groups whose kept copy is missing
+// or changed size since the last update are skipped, as are the copies that
+// changed. Returns the number of groups.
+func WriteCleanupScript(w io.Writer, hashMap map[utils.HashPair][]string, roots []string, keepUnder []string) (int, error) {
+	groups := duplicateGroups(hashMap, roots)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#!/bin/sh")
+	fmt.Fprintln(bw, "# Cleanup script generated by duplito from the files database, REVIEW BEFORE RUNNING.")
+	fmt.Fprintln(bw, "# Each group keeps its first copy, uncomment the rm line (remove) or the ln line")
+	fmt.Fprintln(bw, "# (replace with a hardlink to the kept copy) of the copies to clean up.")
+	fmt.Fprintln(bw, "# Groups of a quick hash (-u) database may hold different files, check them first.")
+	for i, hashPair := range groups {
+		paths := append([]string(nil), hashMap[hashPair]...)
+		sort.Strings(paths)
+		keeper := keeperOf(paths, keepUnder)
+		fmt.Fprintf(bw, "\n# group %d: %d copies of %s, hash %s\n",
+			i+1, len(paths), utils.RepresentBytes(hashPair.Filesize), hashPair.Hash)
+		if strings.ContainsAny(strings.Join(paths, ""), "\n\r") {
+			//a line break would turn the rest of the path into an active command
+			fmt.Fprintln(bw, "# skipped, a path contains a line break")
+			continue
+		}
+		if !presentCopy(keeper, hashPair.Filesize) {
+			fmt.Fprintf(bw, "# skipped, the kept copy %s is missing or changed since the last update\n", shellQuote(keeper))
+			continue
+		}
+		fmt.Fprintf(bw, "# keep %s\n", shellQuote(keeper))
+		for _, path := range paths {
+			if path == keeper {
+				continue
+			}
+			if utils.IsUnderAny(path, keepUnder) {
+				fmt.Fprintf(bw, "# keep %s\n", shellQuote(path))
+				continue
+			}
+			if !presentCopy(path, hashPair.Filesize) {
+				fmt.Fprintf(bw, "# skip %s, missing or changed since the last update\n", shellQuote(path))
+				continue
+			}
+			if sameFile(path, keeper) {
+				fmt.Fprintf(bw, "# skip %s, same file as the kept copy\n", shellQuote(path))
+				continue
+			}
+			fmt.Fprintf(bw, "#rm -- %s\n", shellQuote(path))
+			fmt.Fprintf(bw, "#ln -f -- %s %s\n", shellQuote(keeper), shellQuote(path))
+		}
+	}
+	return len(groups), bw.Flush()
+}
+
+// anyUnder tells if at least one of the paths is under the roots.
+func anyUnder(paths []string, roots []string) bool {
+	for _, path := range paths {
+		if utils.IsUnderAny(path, roots) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteFlatGroups writes the duplicate groups of the database having a copy
+// under roots (all the groups when roots is empty), one path per line, the
+// groups followed by separator (an empty separator is a blank line). With
+// marker each group starts with a "# group N hash=H size=S copies=C" line.
+// Returns the number of groups.
+func WriteFlatGroups(w io.Writer, hashMap map[utils.HashPair][]string, roots []string, separator string,
+	marker bool) (int, error) {
+	groups := duplicateGroups(hashMap, roots)
+	bw := bufio.NewWriter(w)
+	for i, hashPair := range groups {
+		paths := append([]string(nil), hashMap[hashPair]...)
+		sort.Strings(paths)
+		if marker {
+			fmt.Fprintf(bw, "# group %d hash=%s size=%d copies=%d\n", i+1, hashPair.Hash, hashPair.Filesize, len(paths))
+		}
+		for _, path := range paths {
+			fmt.Fprintln(bw, utils.EscapeControl(path))
+		}
+		fmt.Fprintln(bw, separator)
+	}
+	return len(groups), bw.Flush()
+}
+
+// WriteRedundantPaths writes, NUL terminated, the paths of the copies that
+// can be removed: for each duplicate group all the copies but the kept one
+// (the same of the cleanup script), only those under roots when provided.
+// Copies that are the kept file itself (same inode), under keepUnder, missing
+// or changed size since the last update are left out, as are the groups whose
+// kept copy is missing or changed size. The caller must check that the
+// database hashes the whole content. Returns the number of paths.
+func WriteRedundantPaths(w io.Writer, hashMap map[utils.HashPair][]string, roots []string, keepUnder []string) (int, error) {
+	bw := bufio.NewWriter(w)
+	numPaths := 0
+	for _, hashPair := range duplicateGroups(hashMap, roots) {
+		paths := append([]string(nil), hashMap[hashPair]...)
+		sort.Strings(paths)
+		keeper := keeperOf(paths, keepUnder)
+		if !presentCopy(keeper, hashPair.Filesize) {
+			//the last copy could be among the paths printed
+			slog.Warn("skipping duplicate group, the kept copy is missing or changed since the last update", "keeper", keeper)
+			continue
+		}
+		for _, path := range paths {
+			if path == keeper || (len(roots) > 0 && !utils.IsUnderAny(path, roots)) || utils.IsUnderAny(path, keepUnder) {
+				continue
+			}
+			if !presentCopy(path, hashPair.Filesize) {
+				slog.Warn("skipping copy changed since the last update", "path", path)
+				continue
+			}
+			if sameFile(path, keeper) {
+				continue
+			}
+			bw.WriteString(path)
+			bw.WriteByte(0)
+			numPaths++
+		}
+	}
+	return numPaths, bw.Flush()
+}
+
+// tsvEscaper escapes the path field of --tsv: backslash, tab, newline and
+// carriage return become \\, \t, \n and \r, so each file is one line of three
+// fields.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// WriteTSV writes a hash<TAB>size<TAB>path line for each file of the database
+// under roots (all of them when roots is empty), in no particular order. The
+// hash is empty for the files never hashed (unique size), the path is escaped
+// by tsvEscaper. Returns the number of lines.
+func WriteTSV(w io.Writer, hashMap map[utils.HashPair][]string, roots []string) (int, error) {
+	bw := bufio.NewWriter(w)
+	numLines := 0
+	err := config.ForEachFile(hashMap, func(path string, hashPair utils.HashPair) error {
+		if len(roots) > 0 && !utils.IsUnderAny(path, roots) {
+			return nil
+		}
+		numLines++
+		_, err := fmt.Fprintf(bw, "%s\t%d\t%s\n", hashPair.Hash, hashPair.Filesize, tsvEscaper.Replace(path))
+		return err
+	})
+	if err != nil {
+		return numLines, err
+	}
+	return numLines, bw.Flush()
+}
@@ -0,0 +1,84 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// inodeUse counts the catalogued paths of a group sharing one inode
+type inodeUse struct {
+	numPaths int
+	nlink    uint64 // links of the inode on disk, more than numPaths when linked outside the catalog
+	kept     bool   // at least one of the paths is not acted on
+}
+
+// DedupeSimulationReport prints the disk used by the catalogued files before
+// and after removing the duplicates of the groups having a copy under roots
+// (all the groups when roots is empty), deleted or replaced by hardlinks or
+// reflinks to the kept copy: the bytes freed are the same. The kept copy is
+// the one of --gen-script, copies outside roots and under keepUnder are never
+// acted on. Hardlinked copies use disk space once, and an inode still linked
+// from outside the catalog frees nothing. Nothing is changed on disk.
+func DedupeSimulationReport(hashMap map[utils.HashPair][]string, roots []string, keepUnder []string) {
+	var before, after, naive int64
+	var numGroups, numMissing int
+	for hashPair, paths := range hashMap {
+		if hashPair.Hash == "" || utils.IsPartialHash(hashPair.Hash) || utils.IsSymlinkHash(hashPair.Hash) ||
+			len(paths) < 2 || (len(roots) > 0 && !anyUnder(paths, roots)) {
+			//unique files, unknown content or symlinks, never acted on
+			before += hashPair.Filesize * int64(len(paths))
+			after += hashPair.Filesize * int64(len(paths))
+			continue
+		}
+		numGroups++
+		naive += hashPair.Filesize * int64(len(paths)-1)
+
+		var present []string
+		identities := make(map[string]utils.FileID)
+		inodes := make(map[utils.FileID]*inodeUse)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || info.Size() != hashPair.Filesize {
+				numMissing++
+				continue
+			}
+			present = append(present, path)
+			id, nlink, ok := utils.FileIdentity(info)
+			if !ok {
+				//no inode information, each path is its own file
+				id, nlink = utils.FileID{Dev: ^uint64(0), Ino: uint64(len(present))}, 1
+			}
+			identities[path] = id
+			if inodes[id] == nil {
+				inodes[id] = &inodeUse{nlink: nlink}
+			}
+			inodes[id].numPaths++
+		}
+		if len(present) == 0 {
+			continue
+		}
+		sort.Strings(present)
+		keeper := keeperOf(present, keepUnder)
+		for _, path := range present {
+			if path == keeper || (len(roots) > 0 && !utils.IsUnderAny(path, roots)) || utils.IsUnderAny(path, keepUnder) {
+				inodes[identities[path]].kept = true
+			}
+		}
+		for _, use := range inodes {
+			before += hashPair.Filesize
+			if use.kept || use.nlink > uint64(use.numPaths) {
+				after += hashPair.Filesize
+			}
+		}
+	}
+
+	utils.PrintSeparator(SEP_WIDTH)
+	fmt.Println("DEDUPE SIMULATION (delete, hardlink or reflink of the duplicates, nothing changed)")
+	fmt.Printf("\tGROUPS:\t\t%-20dMISSING COPIES: %d\n", numGroups, numMissing)
+	fmt.Printf("\tDISK USED:\t%-20sAFTER: %s\n", utils.RepresentBytes(before), utils.RepresentBytes(after))
+	fmt.Printf("\tFREED:\t\t%-20sDUPLICATE SIZE: %s\n", utils.RepresentBytes(before-after), utils.RepresentBytes(naive))
+	utils.PrintSeparator(SEP_WIDTH)
+}
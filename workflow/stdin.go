@@ -0,0 +1,77 @@
+package workflow
+
+import (
+	"crypto/md5"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	config "github.com/ftarlao/duplito/config"
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// StreamMatches reads r (e.g. stdin) to the end and returns its size and the
+// catalogued files with the same content. The stream is spooled to a temporary
+// file so that it can be hashed the way the database was built, the quick hash
+// needs to seek; metadata nil means the default quick hash. Files whose size
+// is unique in the database were never hashed and are compared byte by byte,
+// as are the files of sparse-aware databases and of databases hashing the
+// extended attributes, which the stream does not have.
+func StreamMatches(r io.Reader, hashMap map[utils.HashPair][]string, metadata *config.Metadata) (int64, []string, error) {
+	spool, err := os.CreateTemp("", "duplito-stdin-*")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size == 0 {
+		return 0, nil, nil //empty files are not catalogued
+	}
+
+	var matches, candidates []string
+	if metadata != nil && (metadata.SparseAware || metadata.IncludeXattrs) {
+		//the extents and the extended attributes of the spooled copy are not
+		//the ones of the original
+		for hashPair, group := range hashMap {
+			if hashPair.Filesize == size {
+				candidates = append(candidates, group...)
+			}
+		}
+	} else {
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return size, nil, err
+		}
+		var hashSum string
+		switch {
+		case metadata.QuickHashed(size):
+			hashSum, err = utils.QuickHashGen(md5.New(), spool, quickHashArea, size, metadata.QuickTinyMultiplier())
+		case metadata.MaxHashBytes > 0:
+			hashSum, err = utils.HashGenN(md5.New(), spool, metadata.MaxHashBytes)
+		default:
+			hashSum, err = utils.HashGen(md5.New(), spool)
+		}
+		if err != nil {
+			return size, nil, err
+		}
+		slog.Debug("hashed stream", "size", size, "hash", hashSum)
+		matches = append(matches, hashMap[utils.HashPair{Filesize: size, Hash: hashSum}]...)
+		candidates = hashMap[utils.HashPair{Filesize: size, Hash: ""}]
+	}
+	for _, path := range candidates {
+		equal, err := sameContent(spool.Name(), path)
+		if err != nil {
+			slog.Warn("failed to compare file", "path", path, "err", err)
+			continue
+		}
+		if equal {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return size, matches, nil
+}
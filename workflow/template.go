@@ -0,0 +1,135 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// Status labels used by the --format {status} placeholder and by --json-all
+const (
+	StatusZeroSize     = "ZERO_SIZE"
+	StatusNotInDB      = "NOT_IN_DATABASE"
+	StatusNotDuplicate = "NOT_DUPLICATE"
+	StatusDuplicate    = "DUPLICATE"
+	StatusUnknown      = "UNKNOWN" // never hashed but sharing its size, or partially hashed: content unknown
+)
+
+// JSONSchemaVersion is the "version" field of the JSON records, bump it when
+// the fields change so that parsers can adapt.
+const JSONSchemaVersion = 1
+
+// fileRecord holds the per-file data shown by a line template or by --json-all.
+type fileRecord struct {
+	Version    int      `json:"version"` // JSONSchemaVersion, set by writeJSONRecord
+	Path       string   `json:"path"`
+	Size       int64    `json:"size"`
+	Hash       string   `json:"hash"`
+	Status     string   `json:"status"`
+	Duplicates []string `json:"duplicates"`         // other copies, empty for unique files
+	NonUTF8    bool     `json:"non_utf8,omitempty"` // path is not valid UTF-8, decoded or with replacement characters
+}
+
+// writeJSONRecord writes the record as a single JSON line (JSON Lines format).
+func writeJSONRecord(w io.Writer, rec fileRecord) {
+	rec.Version = JSONSchemaVersion
+	if rec.Duplicates == nil {
+		rec.Duplicates = []string{}
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		slog.Error("failed to encode record", "path", rec.Path, "err", err)
+		return
+	}
+	w.Write(append(line, '\n'))
+}
+
+// otherPaths returns the members of group except path.
+func otherPaths(path string, group []string) []string {
+	others := make([]string, 0, len(group))
+	for _, member := range group {
+		if member != path {
+			others = append(others, member)
+		}
+	}
+	return others
+}
+
+// templatePart is a literal text chunk or, when field is set, a placeholder.
+type templatePart struct {
+	literal string
+	field   string
+}
+
+// lineTemplate is a --format string parsed once, rendered for each file.
+type lineTemplate []templatePart
+
+// supported placeholders, {size} is in bytes while {hsize} is human readable
+var templateFields = map[string]bool{
+	"filename": true,
+	"path":     true,
+	"size":     true,
+	"hsize":    true,
+	"hash":     true,
+	"status":   true,
+}
+
+// parseLineTemplate splits the format string into literals and placeholders.
+// Returns an error for unknown or unterminated placeholders.
+func parseLineTemplate(format string) (lineTemplate, error) {
+	var tmpl lineTemplate
+	rest := format
+	for len(rest) > 0 {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			tmpl = append(tmpl, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			tmpl = append(tmpl, templatePart{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in format %q", format)
+		}
+		field := rest[start+1 : start+end]
+		if !templateFields[field] {
+			return nil, fmt.Errorf("unknown placeholder {%s} in format %q", field, format)
+		}
+		tmpl = append(tmpl, templatePart{field: field})
+		rest = rest[start+end+1:]
+	}
+	return tmpl, nil
+}
+
+// render writes one line for the record, terminator included (newline, or NUL
+// for --null-terminated).
+func (t lineTemplate) render(w io.Writer, rec fileRecord, terminator byte) {
+	var sb strings.Builder
+	for _, part := range t {
+		switch part.field {
+		case "":
+			sb.WriteString(part.literal)
+		case "filename":
+			sb.WriteString(filepath.Base(rec.Path))
+		case "path":
+			sb.WriteString(rec.Path)
+		case "size":
+			sb.WriteString(strconv.FormatInt(rec.Size, 10))
+		case "hsize":
+			sb.WriteString(utils.RepresentBytes(rec.Size))
+		case "hash":
+			sb.WriteString(rec.Hash)
+		case "status":
+			sb.WriteString(rec.Status)
+		}
+	}
+	sb.WriteByte(terminator)
+	io.WriteString(w, sb.String())
+}
@@ -0,0 +1,83 @@
+package workflow
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	utils "github.com/ftarlao/duplito/utils"
+)
+
+// matchVerifier full-hashes the members of a catalogued duplicate group on
+// demand, so groups built with the quick hash can be split by real content.
+// Hashes are cached by path, it is safe for concurrent use by folder workers.
+type matchVerifier struct {
+	mu       sync.Mutex
+	fullHash map[string]string       // path -> full hash, "" when the file is unreadable
+	checked  map[utils.HashPair]bool // groups verified
+	split    map[utils.HashPair]bool // groups verified having members with a different content
+}
+
+func newMatchVerifier() *matchVerifier {
+	return &matchVerifier{
+		fullHash: make(map[string]string),
+		checked:  make(map[utils.HashPair]bool),
+		split:    make(map[utils.HashPair]bool),
+	}
+}
+
+// hashOf returns the cached full hash of path, computing it the first time.
+func (v *matchVerifier) hashOf(path string) string {
+	v.mu.Lock()
+	sum, ok := v.fullHash[path]
+	v.mu.Unlock()
+	if ok {
+		return sum
+	}
+	file, err := os.Open(path)
+	if err == nil {
+		sum, err = utils.HashGen(md5.New(), file)
+		file.Close()
+	}
+	if err != nil {
+		slog.Warn("failed to verify file", "path", path, "err", err)
+		sum = ""
+	}
+	v.mu.Lock()
+	v.fullHash[path] = sum
+	v.mu.Unlock()
+	return sum
+}
+
+// confirmedDuplicates returns the members of group, the files of hashPair in
+// the database, whose full hash is the same as the one of path (path
+// included). When path itself cannot be read the group is returned unchanged,
+// members that cannot be read are dropped.
+func (v *matchVerifier) confirmedDuplicates(path string, hashPair utils.HashPair, group []string) []string {
+	own := v.hashOf(path)
+	if own == "" {
+		return group
+	}
+	confirmed := make([]string, 0, len(group))
+	for _, member := range group {
+		if member == path || v.hashOf(member) == own {
+			confirmed = append(confirmed, member)
+		}
+	}
+	v.mu.Lock()
+	v.checked[hashPair] = true
+	if len(confirmed) != len(group) {
+		v.split[hashPair] = true
+	}
+	v.mu.Unlock()
+	return confirmed
+}
+
+// summary returns the line with the number of groups verified and split.
+func (v *matchVerifier) summary() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return fmt.Sprintf("\tVERIFIED GROUPS:%-20dSPLIT: %d\n", len(v.checked), len(v.split))
+}
@@ -5,12 +5,21 @@ import (
 	"crypto/md5"
 	"errors"
 	"fmt"
+	"hash"
+	"io/fs"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	cfg "github.com/ftarlao/duplito/config"
 	counters "github.com/ftarlao/duplito/counters"
@@ -39,6 +48,7 @@ type fileTask struct {
 	Filesize int64
 	RealHash bool
 	IsUpdate bool
+	Folder   *trackedFolder //folder of the walk for the checkpoint, nil for promoted and kept files
 }
 
 // fileResult represents the result of processing a file.
@@ -46,7 +56,27 @@ type fileResult struct {
 	Path       string
 	HashPairID utils.HashPair //contains also filesize
 	Err        error
+	ReadErr    error //with a partial hash (--resume-hash), the read error of the file
 	IsUpdate   bool
+	Folder     *trackedFolder
+}
+
+// symlinkEntry returns, for --hash-symlinks, the absolute path and the database
+// key of a symbolic link, its size is the length of the target path string.
+// ok is false for other entries, when the option is off or the link is unreadable.
+func symlinkEntry(path string, d os.DirEntry, opt cfg.Options) (absPath string, hashPair utils.HashPair, ok bool) {
+	if !opt.HashSymlinks || d == nil || d.Type()&fs.ModeSymlink == 0 {
+		return "", utils.HashPair{}, false
+	}
+	target, err := os.Readlink(path)
+	if err == nil {
+		absPath, err = filepath.Abs(path)
+	}
+	if err != nil {
+		slog.Warn("failed to read symbolic link", "path", path, "err", err)
+		return "", utils.HashPair{}, false
+	}
+	return absPath, utils.HashPair{Filesize: int64(len(target)), Hash: utils.SymlinkHash(target)}, true
 }
 
 // findFiles walks the directory and sends file tasks to a channel.
@@ -57,12 +87,61 @@ func findFiles(
 	wg *sync.WaitGroup,
 	opt cfg.Options,
 	ctx context.Context,
+	kept map[string]utils.HashPair, //files of folders already done in an interrupted update
+	skipMagics [][]byte, //--skip-magic signatures
+	resume *Resume,
+	progress *walkProgress,
 ) {
 	defer wg.Done()
 
-	sizeToFileTask := make(map[int64]fileTask) //record the first filetask for a filesize value
+	//the sizes seen, at most maxTrackedSizes: past it they are forgotten, the files
+	//of a forgotten size found again are hashed by hashForgottenSizes after the walk
+	firstOfSize := make(map[int64]string)   //absolute path of the only file of a size found so far, not hashed
+	hashedSizes := make(map[int64]struct{}) //sizes whose files are all hashed
+	for path, hashPair := range kept {
+		//kept files take part in the size matching, the unhashed ones are hashed when needed
+		if utils.IsSymlinkHash(hashPair.Hash) {
+			continue //the size of a symlink is the length of its target
+		}
+		if hashPair.Hash != "" {
+			hashedSizes[hashPair.Filesize] = struct{}{}
+			delete(firstOfSize, hashPair.Filesize)
+		} else if _, hashed := hashedSizes[hashPair.Filesize]; !hashed {
+			firstOfSize[hashPair.Filesize] = path
+		}
+	}
+	absRoots := make([]string, len(paths))
+	for i, pathname := range paths {
+		absRoots[i], _ = filepath.Abs(pathname)
+	}
 
-	for _, pathname := range paths {
+	// sendTask queues a task unless the search has been cancelled, this way the
+	// walk never blocks forever on workers that already stopped.
+	sendTask := func(task fileTask) bool {
+		select {
+		case tasks <- task:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	// hashFirst sends the delayed hashing of the first file of a size, its
+	// unhashed result was already collected and counted by its folder.
+	hashFirst := func(filesize int64, absPath string) bool {
+		delete(firstOfSize, filesize)
+		hashedSizes[filesize] = struct{}{}
+		return sendTask(fileTask{Path: absPath, AbsPath: absPath, Filesize: filesize, RealHash: true, IsUpdate: true})
+	}
+
+	var currentDir string            //folder being walked
+	var currentFolder *trackedFolder //the same, for the checkpoint
+	var numFiles int64
+	dirs := utils.NewDirTracker()
+	for rootIdx, pathname := range paths {
+		//a root that is a file is a folder of its own for the checkpoint
+		currentDir = absRoots[rootIdx]
+		currentFolder = progress.tracker.enter(absRoots[rootIdx], currentDir)
+		currentDone := resume.isDone(absRoots, rootIdx, absRoots[rootIdx])
 		err := utils.HybridWalk(pathname, func(path string, d os.DirEntry, err error) error {
 			select {
 			case <-ctx.Done():
@@ -73,52 +152,296 @@ func findFiles(
 
 			}
 
+			if skip, skipErr := excluded(path, pathname, d, opt); skip {
+				return skipErr
+			}
+			if err == nil && d != nil && d.IsDir() {
+				if !dirs.FirstVisit(path, d) {
+					slog.Info("skipping folder already walked from another path", "path", path)
+					return filepath.SkipDir
+				}
+				//files always follow their folder, the previous folder is complete
+				if dir, _ := filepath.Abs(path); dir != currentDir {
+					currentDir = dir
+					currentFolder = progress.tracker.enter(absRoots[rootIdx], currentDir)
+				}
+				currentDone = resume.isDone(absRoots, rootIdx, currentDir)
+				slog.Debug("walking folder", "path", currentDir, "resumed", currentDone)
+			}
+			if currentDone && (d == nil || !d.IsDir()) {
+				return nil //already hashed by the interrupted update
+			}
+
 			absPath, filesize, checkErr := utils.CheckFile(path, d, err, opt.RecurseFlag, path)
 			if checkErr != nil && checkErr != filepath.SkipDir {
-				fmt.Fprintf(os.Stderr, "Error while accessing file %s details: %v\n", path, err)
+				//a failed result, the collector reports it and counts it in --max-error-rate
+				progress.tracker.sent(currentFolder)
+				results <- fileResult{Path: path, Err: checkErr, Folder: currentFolder}
 				if opt.IgnoreErrorsFlag {
 					checkErr = nil
 				}
 				return checkErr
 			}
+			if linkPath, hashPair, ok := symlinkEntry(path, d, opt); ok {
+				//the target string is the content, no file to read
+				progress.tracker.sent(currentFolder)
+				results <- fileResult{Path: linkPath, HashPairID: hashPair, Folder: currentFolder}
+				return nil
+			}
 			if absPath == "" { // Skipped by checkFile (e.g., directory, symlink, non-regular, or ignored error)
 				return nil
 			}
+			if filesize < opt.MinIndexBytes {
+				return nil //too small to matter, never enters the database
+			}
+			if len(skipMagics) > 0 {
+				skip, magicErr := utils.HasMagic(path, skipMagics, opt.NoAtime)
+				if magicErr != nil {
+					slog.Warn("failed to read file signature", "path", path, "err", magicErr)
+				}
+				if skip {
+					slog.Debug("skipping file by signature", "path", path)
+					return nil
+				}
+			}
+			if opt.MaxFiles > 0 && numFiles >= opt.MaxFiles {
+				return errFileLimit
+			}
+			numFiles++
+			progress.found.Add(1)
 
-			ft := fileTask{Path: path, AbsPath: absPath, Filesize: filesize, RealHash: false, IsUpdate: false}
-			if oldTask, ok := sizeToFileTask[filesize]; ok {
-				//Other file with same size
+			ft := fileTask{Path: path, AbsPath: absPath, Filesize: filesize, RealHash: false, IsUpdate: false,
+				Folder: currentFolder}
+			progress.tracker.sent(currentFolder)
+			if firstPath, ok := firstOfSize[filesize]; ok {
+				//Other file with same size, we have not processed the first, let's do it,
+				//it's like a delayed processing
 				ft.RealHash = true
-				if !oldTask.RealHash {
-					//we have not processed the first, let's do it, it's like a delayed processing
-					oldTask.RealHash = true
-					oldTask.IsUpdate = true
-					sizeToFileTask[filesize] = oldTask //update the status for this size
-					tasks <- oldTask                   //sends also the previous task (recalcluate hash)
+				if !hashFirst(filesize, firstPath) {
+					return errors.New("File search stops")
+				}
+				if !sendTask(ft) {
+					return errors.New("File search stops")
+				}
+			} else if _, hashed := hashedSizes[filesize]; hashed {
+				ft.RealHash = true
+				if !sendTask(ft) {
+					return errors.New("File search stops")
 				}
-				tasks <- ft
 			} else {
-				sizeToFileTask[filesize] = ft
+				firstOfSize[filesize] = absPath
 				hashPair := utils.HashPair{
 					Filesize: filesize,
 					Hash:     "",
 				}
-				results <- fileResult{Path: absPath, Err: nil, IsUpdate: false, HashPairID: hashPair}
+				results <- fileResult{Path: absPath, Err: nil, IsUpdate: false, HashPairID: hashPair, Folder: currentFolder}
+			}
+			if len(firstOfSize)+len(hashedSizes) > maxTrackedSizes {
+				//nothing is read now, the sizes found again are sorted out after the walk
+				slog.Info("forgetting the filesizes seen so far to bound memory", "sizes", len(firstOfSize)+len(hashedSizes))
+				firstOfSize = make(map[int64]string)
+				hashedSizes = make(map[int64]struct{})
+				progress.forgotSizes = true
 			}
 
 			return nil
 		})
+		//the folder being walked is not complete when the walk stops
+		if err == errFileLimit {
+			slog.Warn("max files limit reached, stopping the walk", "max_files", opt.MaxFiles)
+			progress.aborted = true
+			progress.limitReached = true
+			break
+		}
+		if err != nil && (ctx.Err() != nil || !opt.IgnoreErrorsFlag) {
+			progress.aborted = true
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error during directory walk %s: %v\n", pathname, err)
-			if !opt.IgnoreErrorsFlag {
+			slog.Error("directory walk stopped", "path", pathname, "err", err)
+			if !opt.IgnoreErrorsFlag || ctx.Err() != nil {
 				break
 			}
+			progress.tracker.fail(currentFolder) //the rest of the root was not walked
 		}
+		progress.tracker.close(currentFolder)
 	}
-	//fmt.Printf("\nUnique sizes: %d\n", len(sizeToFileTask))  //INSPECTION CODE
+	//fmt.Printf("\nUnique sizes: %d\n", len(firstOfSize))  //INSPECTION CODE
+	progress.walkDone.Store(true)
 	close(tasks) // Important: close the channel when all tasks are sent
 }
 
+// maxTrackedSizes bounds the filesizes findFiles remembers, about 50 bytes
+// each, the path of a file of a size found once is shared with the collector
+const maxTrackedSizes = 1 << 21
+
+// forgottenSizeTasks returns the tasks hashing the unhashed files whose size
+// turns out to be shared: first files of sizes findFiles forgot, found again
+// after that.
+func forgottenSizeTasks(hashMap map[utils.HashPair][]string) []fileTask {
+	hashedSizes := make(map[int64]bool)
+	for hashPair := range hashMap {
+		if hashPair.Hash != "" && !utils.IsSymlinkHash(hashPair.Hash) {
+			hashedSizes[hashPair.Filesize] = true
+		}
+	}
+	var tasks []fileTask
+	for hashPair, paths := range hashMap {
+		if hashPair.Hash != "" || (len(paths) < 2 && !hashedSizes[hashPair.Filesize]) {
+			continue
+		}
+		for _, path := range paths {
+			tasks = append(tasks, fileTask{Path: path, AbsPath: path, Filesize: hashPair.Filesize, RealHash: true, IsUpdate: true})
+		}
+	}
+	return tasks
+}
+
+// hashForgottenSizes hashes, with the workers of the update, the files left
+// unhashed by findFiles although their size is shared, and moves them to their
+// hash in hashMap. It stops at ctx cancellation, the files not hashed keep the
+// empty hash.
+func hashForgottenSizes(ctx context.Context, hashMap map[utils.HashPair][]string, opt cfg.Options,
+	openSlots chan struct{}, cancel context.CancelFunc, progress *walkProgress) {
+	pending := forgottenSizeTasks(hashMap)
+	if len(pending) == 0 {
+		return
+	}
+	slog.Info("hashing the files of the forgotten filesizes found again", "files", len(pending))
+	tasks := make(chan fileTask, opt.NumThreads*2)
+	results := make(chan fileResult, opt.NumThreads*2)
+	go func() {
+		defer close(tasks)
+		for _, task := range pending {
+			select {
+			case tasks <- task:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wgWorkers sync.WaitGroup
+	for i := 0; i < opt.NumThreads; i++ {
+		wgWorkers.Add(1)
+		go fileWorker(i+1, tasks, results, &wgWorkers, opt, openSlots, cancel)
+	}
+	go func() {
+		wgWorkers.Wait()
+		close(results)
+	}()
+	for res := range results {
+		if res.Err != nil {
+			slog.Warn("failed to read file", "path", res.Path, "err", res.Err)
+			progress.fileErrors.add(res.Path, res.Err)
+			continue
+		}
+		if res.ReadErr != nil {
+			progress.fileErrors.add(res.Path, fmt.Errorf("%w (only the readable part is hashed)", res.ReadErr))
+		}
+		unhashed := utils.HashPair{Filesize: res.HashPairID.Filesize}
+		if hashMap[unhashed] = otherPaths(res.Path, hashMap[unhashed]); len(hashMap[unhashed]) == 0 {
+			delete(hashMap, unhashed)
+		}
+		hashMap[res.HashPairID] = append(hashMap[res.HashPairID], res.Path)
+	}
+}
+
+// countDatabaseFiles returns the number of files in the database, 0 when it
+// cannot be loaded (the progress is then shown without estimate).
+func countDatabaseFiles() int64 {
+	hashMap, err := cfg.LoadMap()
+	if err != nil {
+		slog.Warn("failed to load the database for the progress estimate", "err", err)
+		return 0
+	}
+	var numFiles int64
+	cfg.EachFile(hashMap, func(string, utils.HashPair) {
+		numFiles++
+	})
+	return numFiles
+}
+
+// quickHashArea is the size of the areas read by the quick hash (-u)
+const quickHashArea int64 = 2 * 1024 * 1024
+
+// hashTask opens and hashes the file of a task, the error is in the result.
+func hashTask(
+	id int,
+	task fileTask,
+	hashEngine hash.Hash,
+	opt cfg.Options,
+	openSlots chan struct{}, //bounds the files open at the same time, nil when unlimited
+) fileResult {
+	if openSlots != nil {
+		openSlots <- struct{}{}
+	}
+	var file *os.File
+	var err error
+	if opt.NoAtime {
+		file, err = utils.OpenNoAtime(task.Path)
+	} else {
+		file, err = os.Open(task.Path)
+	}
+	if err != nil {
+		if openSlots != nil {
+			<-openSlots
+		}
+		if errors.Is(err, syscall.EMFILE) {
+			err = fmt.Errorf("%w (open files limit reached, lower --threads or set --max-open-files)", err)
+		}
+		//fmt.Fprintf(os.Stderr, "Worker %d: Error opening %s: %v\n", id, task.Path, err)
+		return fileResult{Path: task.AbsPath, Err: fmt.Errorf("Worker %d, failed to open %s: %w", id, task.Path, err),
+			IsUpdate: task.IsUpdate, Folder: task.Folder}
+	}
+	var hashSum string
+	if adviseErr := utils.AdviseBeforeRead(file, opt.Fadvise); adviseErr != nil {
+		slog.Debug("fadvise failed", "path", task.Path, "err", adviseErr)
+	}
+
+	if !opt.UpdateFullFlag && (opt.FullHashAbove == 0 || task.Filesize <= opt.FullHashAbove) {
+		hashSum, err = utils.QuickHashGen(hashEngine, file, quickHashArea, task.Filesize, opt.TinyMultiplier)
+	} else if opt.MaxHashBytes > 0 {
+		//capped full hash, files sharing the first MaxHashBytes are duplicates
+		hashSum, err = utils.HashGenN(hashEngine, file, opt.MaxHashBytes)
+	} else if opt.SparseAware {
+		//full hash of the allocated extents only
+		hashSum, err = utils.SparseHashGen(hashEngine, file, task.Filesize)
+	} else {
+		//remains only the full hash
+		hashSum, err = utils.HashGen(hashEngine, file)
+	}
+
+	var readErr error
+	if err != nil && opt.ResumeHash {
+		//best effort on failing media, the readable prefix is hashed
+		slog.Warn("read error, hashing the readable part only", "path", task.Path, "err", err)
+		readErr = err
+		hashSum, err = utils.PartialHashGen(hashEngine, file)
+	}
+	if err == nil && opt.IncludeXattrs {
+		hashSum, err = utils.FoldXattrs(hashEngine, hashSum, task.Path)
+	}
+
+	hashPair := utils.HashPair{
+		Filesize: task.Filesize,
+		Hash:     hashSum,
+	}
+	if adviseErr := utils.AdviseAfterRead(file, opt.Fadvise); adviseErr != nil {
+		slog.Debug("fadvise failed", "path", task.Path, "err", adviseErr)
+	}
+	file.Close() // Close the file immediately after hashing
+	if openSlots != nil {
+		<-openSlots
+	}
+
+	if err != nil {
+		//fmt.Fprintf(os.Stderr, "Worker %d: Error hashing %s: %v\n", id, task.Path, err)
+		return fileResult{Path: task.AbsPath, Err: fmt.Errorf("Worker %d, failed to hash %s: %w", id, task.Path, err),
+			IsUpdate: task.IsUpdate, Folder: task.Folder}
+	}
+	return fileResult{Path: task.AbsPath, HashPairID: hashPair, Err: nil, ReadErr: readErr, IsUpdate: task.IsUpdate,
+		Folder: task.Folder}
+}
+
 // fileWorker processes file tasks from the input channel and sends results to the output channel.
 func fileWorker(
 	id int,
@@ -126,87 +449,136 @@ func fileWorker(
 	results chan fileResult,
 	wg *sync.WaitGroup,
 	opt cfg.Options,
+	openSlots chan struct{}, //bounds the files open at the same time, nil when unlimited
 	cancel context.CancelFunc,
 ) {
 	defer wg.Done()
 	myHashEngine := md5.New()
 	for task := range tasks {
-		file, err := os.Open(task.Path)
-		if err != nil {
-			//fmt.Fprintf(os.Stderr, "Worker %d: Error opening %s: %v\n", id, task.Path, err)
-			results <- fileResult{Path: task.AbsPath, Err: fmt.Errorf("Worker %d, failed to open %s: %w", id, task.Path, err)}
-			if opt.IgnoreErrorsFlag {
-				continue
-			} else {
-				cancel() //Stops the file walking
-				return
-			}
+		res := hashTask(id, task, myHashEngine, opt, openSlots)
+		results <- res
+		if res.Err != nil && !opt.IgnoreErrorsFlag {
+			cancel() //Stops the file walking, the tasks already queued are still hashed
 		}
-		var hashSum string
-
-		if !opt.UpdateFullFlag {
-			hashSum, err = utils.QuickHashGen(myHashEngine, file, 2*1024*1024, task.Filesize)
-		} else {
-			//remains only the full hash
-			hashSum, err = utils.HashGen(myHashEngine, file)
-		}
-
-		hashPair := utils.HashPair{
-			Filesize: task.Filesize,
-			Hash:     hashSum,
-		}
-		file.Close() // Close the file immediately after hashing
+	}
+}
 
-		if err != nil {
-			//fmt.Fprintf(os.Stderr, "Worker %d: Error hashing %s: %v\n", id, task.Path, err)
-			results <- fileResult{Path: task.AbsPath, Err: fmt.Errorf("Worker %d, failed to hash %s: %w", id, task.Path, err), IsUpdate: task.IsUpdate}
-			if opt.IgnoreErrorsFlag {
+// serialWorker is the only worker of --single-thread: it takes, one at a time
+// and in walk order, the tasks and the results of the walk and passes their
+// results to the collector, so runs are reproducible.
+func serialWorker(
+	tasks chan fileTask,
+	walkResults chan fileResult, //results of the files not to hash, sent by the walk
+	results chan fileResult,
+	wg *sync.WaitGroup,
+	opt cfg.Options,
+	openSlots chan struct{},
+	cancel context.CancelFunc,
+) {
+	defer wg.Done()
+	myHashEngine := md5.New()
+	for tasks != nil || walkResults != nil {
+		select {
+		case task, ok := <-tasks:
+			if !ok {
+				tasks = nil
+				continue
+			}
+			res := hashTask(1, task, myHashEngine, opt, openSlots)
+			results <- res
+			if res.Err != nil && !opt.IgnoreErrorsFlag {
+				cancel()
+			}
+		case res, ok := <-walkResults:
+			if !ok {
+				walkResults = nil
 				continue
-			} else {
-				return
 			}
+			results <- res
 		}
-		results <- fileResult{Path: task.AbsPath, HashPairID: hashPair, Err: nil, IsUpdate: task.IsUpdate}
 	}
 }
 
+// minErrorRateFiles is the number of files processed before --max-error-rate is
+// checked, so that a failure among the first files does not abort the update
+const minErrorRateFiles int64 = 100
+
 // collectResults collects results from workers, updates the hash map, and manages progress display.
+// When maxErrorRate is greater than 0 and the percentage of failed files exceeds
+// it, the update is cancelled.
 func collectResults(
 	results <-chan fileResult,
 	hashMap map[utils.HashPair][]string,
 	wg *sync.WaitGroup,
 	ignoreErrors bool,
+	maxErrorRate float64,
+	cancel context.CancelFunc,
+	progress *walkProgress,
+	estimatedFiles int64, //files in the database before the update, 0 when unknown
 ) {
 	defer wg.Done()
 	var totalBytes int64
 	var numFiles int64
+	var numErrors, numFailedFiles int64 //failed results, failed files not counted in numFiles
 	startTime := time.Now()
 	lastUpdate := time.Now()
+	terminal := utils.IsTerminal()
+	progressShown := false
+
+	//checkErrorRate cancels the update when the failed files are too many
+	checkErrorRate := func() {
+		processed := numFiles + numFailedFiles
+		if maxErrorRate > 0 && processed >= minErrorRateFiles &&
+			float64(numErrors)*100 > maxErrorRate*float64(processed) {
+			slog.Error("error rate exceeded, stopping the update", "errors", numErrors, "files", processed, "max_error_rate", maxErrorRate)
+			cancel()
+			maxErrorRate = 0 //reported once, the queued results are still collected
+		}
+	}
 
 	for res := range results {
 		if res.Err != nil {
-			fmt.Fprintf(os.Stderr, "Error, details: %v\n", res.Err)
+			slog.Warn("failed to read file", "path", res.Path, "err", res.Err)
+			progress.fileErrors.add(res.Path, res.Err)
+			numErrors++
+			if !res.IsUpdate {
+				numFailedFiles++
+			}
+			progress.tracker.collected(res.Folder, true)
+			checkErrorRate()
 			continue
 		}
+		progress.tracker.collected(res.Folder, false)
 		hashMap[res.HashPairID] = append(hashMap[res.HashPairID], res.Path)
+		if res.ReadErr != nil {
+			//catalogued with a partial hash, still a file that could not be read
+			progress.fileErrors.add(res.Path, fmt.Errorf("%w (only the readable part is hashed)", res.ReadErr))
+			numErrors++
+		}
 		if !res.IsUpdate {
 			totalBytes += res.HashPairID.Filesize
 			numFiles++
 		} else {
-			//remove the older fake HashID that has an empty Hash part "" ; this one was useful
-			//ONLY when ONE file has this filesize (no computation performed)
+			//remove the file from the older fake HashID that has an empty Hash part "" ; this one
+			//was useful ONLY when ONE file has this filesize (no computation performed). Other files
+			//may be left there when findFiles forgot the size, hashForgottenSizes hashes them
 			oldPair := utils.HashPair{
 				Filesize: res.HashPairID.Filesize,
 				Hash:     "",
 			}
-			delete(hashMap, oldPair)
+			if hashMap[oldPair] = otherPaths(res.Path, hashMap[oldPair]); len(hashMap[oldPair]) == 0 {
+				delete(hashMap, oldPair)
+			}
 		}
+		checkErrorRate() //also when the errors came before the first minErrorRateFiles files
 
 		// Update progress display
 		duration := time.Since(startTime).Seconds()
 		if duration > 0 && time.Since(lastUpdate) >= 2*time.Second {
 			currentSpeed := int64(float64(totalBytes) / duration)
-			fmt.Printf("\r[Processed_filesize/sec] Read speed: %-25s|\t\tnumber of files: %d", utils.RepresentBytes(currentSpeed)+"/s", numFiles)
+			printProgress(terminal, false, fmt.Sprintf("[Processed_filesize/sec] Read speed: %-25s|\t\tnumber of files: %d%s",
+				utils.RepresentBytes(currentSpeed)+"/s", numFiles, progressLabel(numFiles, estimatedFiles, progress)))
+			progressShown = true
 			lastUpdate = time.Now()
 		}
 	}
@@ -215,29 +587,196 @@ func collectResults(
 	duration := time.Since(startTime).Seconds()
 	if duration > 0 {
 		avgSpeed := int64(float64(totalBytes) / duration)
-		fmt.Println() // Move to a new line after progress updates
-		fmt.Printf("\r[Processed_filesize/sec] Read speed: %-25s|\t\tnumber of files: %d", utils.RepresentBytes(avgSpeed)+"/s", numFiles)
+		if terminal && progressShown {
+			fmt.Println() // the last progress line is kept
+		}
+		printProgress(terminal, true, fmt.Sprintf("[Processed_filesize/sec] Read speed: %-25s|\t\tnumber of files: %d",
+			utils.RepresentBytes(avgSpeed)+"/s", numFiles))
+	}
+}
+
+// printProgress prints a progress line. On a terminal the line replaces the
+// previous one, erased first so no trailing characters of a longer line are
+// left; otherwise (pipes, captured output) each progress line is a line of its
+// own without carriage returns. The final line is not terminated, the caller
+// prints what follows.
+func printProgress(terminal bool, final bool, line string) {
+	switch {
+	case terminal:
+		fmt.Printf("\r\x1b[K%s", line)
+	case final:
+		fmt.Print(line)
+	default:
+		fmt.Println(line)
+	}
+}
+
+// rootStatsTable renders one line per walked path with its files, duplicates
+// and reclaimable bytes.
+func rootStatsTable(paths []string, rootStats []counters.Stats, opt cfg.Options) string {
+	var sb strings.Builder
+	for i, stats := range rootStats {
+		if stats.NumFiles == 0 {
+			fmt.Fprintf(&sb, "  %s\n\tFILES: 0\n", displayPath(paths[i], opt))
+			continue
+		}
+		fmt.Fprintf(&sb, "  %s\n\tFILES: %-10d DUPLICATES: %-9d [%5.1f%%]  DUP_SIZE: %-9s RECLAIMABLE: %s\n",
+			displayPath(paths[i], opt), stats.NumFiles, stats.NumDupFiles, stats.DupPerc(),
+			utils.RepresentBytes(stats.SizeofDupFiles), utils.RepresentBytes(stats.SizeReclaimable))
+	}
+	return sb.String()
+}
+
+// excluded tells if the walk skips the entry because its name matches --exclude
+// (or --exclude-os-junk), the error is filepath.SkipDir for folders. The roots
+// are never excluded.
+func excluded(path string, root string, d os.DirEntry, opt cfg.Options) (bool, error) {
+	if d == nil || path == root || !opt.Excludes.Match(d.Name()) {
+		return false, nil
+	}
+	slog.Debug("skipping excluded entry", "path", path)
+	if d.IsDir() {
+		return true, filepath.SkipDir
 	}
+	return true, nil
 }
 
+// progressLabel returns the percentage of processed files for the progress line,
+// empty without an estimate. Until the walk completes the total is the database
+// count (or the files found so far when more), then it is the exact count.
+func progressLabel(numFiles int64, estimatedFiles int64, progress *walkProgress) string {
+	if estimatedFiles <= 0 {
+		return ""
+	}
+	found := progress.found.Load()
+	if progress.walkDone.Load() {
+		return fmt.Sprintf(" [%5.1f%% of %d]", float64(numFiles)*100/float64(utils.Max64(found, 1)), found)
+	}
+	total := utils.Max64(estimatedFiles, found)
+	return fmt.Sprintf(" [~%4.1f%% of ~%d, estimate]", float64(numFiles)*100/float64(total), total)
+}
+
+// walkProgress is where findFiles stopped, used to build the resume checkpoint
+// and, while walking, how many files were found for the progress display
+type walkProgress struct {
+	tracker      checkpointTracker //folders whose files were all collected
+	aborted      bool              //the walk stopped before visiting every path
+	limitReached bool              //the walk stopped at --max-files
+	forgotSizes  bool              //the walk forgot the sizes seen, to bound memory
+	found        atomic.Int64
+	walkDone     atomic.Bool
+	fileErrors   fileErrors //files that could not be read, reported at the end
+}
+
+// errFileLimit stops the walk once --max-files files have been found
+var errFileLimit = errors.New("max files limit reached")
+
+// ErrScanInterrupted is returned by CalculateFileHashes when the scan stopped
+// early, the returned map still holds every file hashed up to that point.
+var ErrScanInterrupted = errors.New("scan interrupted before completion, the files database is partial")
+
+// ErrFileLimitReached is returned by CalculateFileHashes when the walk stopped
+// at --max-files, it wraps ErrScanInterrupted.
+var ErrFileLimitReached = fmt.Errorf("max files limit reached, %w", ErrScanInterrupted)
+
+// ErrRuntimeExceeded is returned by CalculateFileHashes when the scan timed out
+// at --max-runtime, it wraps ErrScanInterrupted.
+var ErrRuntimeExceeded = fmt.Errorf("max runtime exceeded (timed out), %w", ErrScanInterrupted)
+
 // CalculateFileHashes calculates MD5 hashes for all files in a given directory and its subdirectories
 // using a specified number of concurrent threads.
 // If ignoreErrors is true, skips unreadable/inaccessible files, logs them to stderr, and continues.
 // If ignoreErrors is false, returns an error on the first failure.
 // Displays current read speed in-place and final average read speed.
+// When resume is not nil, the files of the folders completed by the interrupted
+// update are kept from its database instead of being hashed again.
+// When the scan is interrupted (errors or Ctrl-C) the returned checkpoint tells
+// where to resume from, it is nil otherwise.
 func CalculateFileHashes(
 	paths []string,
-	opt cfg.Options) (map[utils.HashPair][]string, error) {
+	opt cfg.Options,
+	resume *Resume) (map[utils.HashPair][]string, *cfg.Checkpoint, error) {
 	// This gives us a 'ctx' to pass to goroutines and a 'cancel' function
-	// to call when we want to stop them.
-	ctx, cancel := context.WithCancel(context.Background())
+	// to call when we want to stop them. Ctrl-C stops the scan gracefully.
+	signalled, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-signalled.Done()
+		stop() //restores the default handling, a second Ctrl-C terminates a stuck run
+	}()
+	ctx := signalled
+	var maxRuntime time.Duration
+	if opt.MaxRuntime != "" {
+		var err error
+		if maxRuntime, err = utils.ParseAge(opt.MaxRuntime); err != nil || maxRuntime <= 0 {
+			return nil, nil, fmt.Errorf("invalid max runtime %q, e.g. 30m or 2h", opt.MaxRuntime)
+		}
+	}
+	var cancel context.CancelFunc
+	if maxRuntime > 0 {
+		//the deadline stops the walk like Ctrl-C, what was hashed is kept
+		ctx, cancel = context.WithTimeout(ctx, maxRuntime)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
 	defer cancel()
 
-	if opt.NumThreads <= 0 {
-		return nil, fmt.Errorf("number of threads must be greater than 0")
+	if opt.NumThreads < 0 {
+		return nil, nil, fmt.Errorf("number of threads must be 0 (auto) or greater")
+	}
+	if opt.NumThreads == 0 {
+		opt.NumThreads = runtime.NumCPU()
+		slog.Debug("hashing threads auto-detected", "threads", opt.NumThreads)
+	}
+	if opt.MaxOpenFiles < 0 {
+		return nil, nil, fmt.Errorf("max open files must be 0 (unlimited) or greater")
+	}
+	if err := utils.CheckFadvise(opt.Fadvise); err != nil {
+		return nil, nil, err
+	}
+	if opt.MaxHashBytes < 0 {
+		return nil, nil, fmt.Errorf("max hash bytes must be 0 (unlimited) or greater")
+	}
+	if opt.MaxHashBytes > 0 && opt.SparseAware {
+		return nil, nil, fmt.Errorf("--max-hash-bytes and --sparse-aware cannot be used together")
+	}
+	if opt.FullHashAbove < 0 {
+		return nil, nil, fmt.Errorf("full hash threshold must be 0 (never) or greater")
+	}
+	if opt.TinyMultiplier < 1 {
+		return nil, nil, fmt.Errorf("tiny multiplier must be 1 or greater")
+	}
+	if opt.IncludeXattrs && !utils.XattrsSupported {
+		return nil, nil, fmt.Errorf("--include-xattrs is not supported on this platform")
+	}
+	if opt.MaxFiles < 0 {
+		return nil, nil, fmt.Errorf("max files must be 0 (unlimited) or greater")
+	}
+	if opt.MaxErrorRate < 0 || opt.MaxErrorRate > 100 {
+		return nil, nil, fmt.Errorf("max error rate must be a percentage between 0 and 100")
+	}
+	skipMagics, err := utils.ParseMagics(opt.SkipMagic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --skip-magic: %w", err)
+	}
+	if opt.MaxErrorRate > 0 {
+		//files errors are skipped, the collector stops the update when they are too many
+		opt.IgnoreErrorsFlag = true
 	}
 
 	hashMap := make(map[utils.HashPair][]string) // This map will be safely updated by the single collector goroutine
+	absRoots := make([]string, len(paths))
+	for i, pathname := range paths {
+		absRoots[i], _ = filepath.Abs(pathname)
+	}
+	if resume != nil && rootIndex(absRoots, resume.Checkpoint.Root) < 0 {
+		return nil, nil, fmt.Errorf("checkpoint root %s is not one of the provided paths", resume.Checkpoint.Root)
+	}
+	kept := resume.keptFiles(absRoots)
+	for path, hashPair := range kept {
+		hashMap[hashPair] = append(hashMap[hashPair], path)
+	}
+	var progress walkProgress
 
 	// Channels for tasks and results
 	tasks := make(chan fileTask, opt.NumThreads*2)     // Buffered channel for files to be processed
@@ -247,19 +786,47 @@ func CalculateFileHashes(
 	var wgWorkers sync.WaitGroup
 	var wgCollector sync.WaitGroup
 
+	walkResults := results // the walk sends the files not to hash directly to the collector
+	if opt.SingleThread {
+		// unbuffered channels and one worker, a file at a time in walk order
+		tasks = make(chan fileTask)
+		results = make(chan fileResult)
+		walkResults = make(chan fileResult)
+	}
+
 	// 1. Start the file finder goroutine
 	wgFindFiles.Add(1)
-	go findFiles(paths, tasks, results, &wgFindFiles, opt, ctx)
+	go findFiles(paths, tasks, walkResults, &wgFindFiles, opt, ctx, kept, skipMagics, resume, &progress)
+
+	var openSlots chan struct{}
+	if opt.MaxOpenFiles > 0 {
+		openSlots = make(chan struct{}, opt.MaxOpenFiles)
+	}
 
 	// 2. Start worker goroutines
-	for i := 0; i < opt.NumThreads; i++ {
+	if opt.SingleThread {
+		go func() {
+			wgFindFiles.Wait()
+			close(walkResults)
+		}()
 		wgWorkers.Add(1)
-		go fileWorker(i+1, tasks, results, &wgWorkers, opt, cancel)
+		go serialWorker(tasks, walkResults, results, &wgWorkers, opt, openSlots, cancel)
+	} else {
+		for i := 0; i < opt.NumThreads; i++ {
+			wgWorkers.Add(1)
+			go fileWorker(i+1, tasks, results, &wgWorkers, opt, openSlots, cancel)
+		}
+	}
+
+	var estimatedFiles int64
+	if opt.ProgressFromDB {
+		estimatedFiles = countDatabaseFiles()
 	}
 
 	// 3. Start results collector goroutine
 	wgCollector.Add(1)
-	go collectResults(results, hashMap, &wgCollector, opt.IgnoreErrorsFlag)
+	go collectResults(results, hashMap, &wgCollector, opt.IgnoreErrorsFlag, opt.MaxErrorRate, cancel,
+		&progress, estimatedFiles)
 
 	// Wait for the file finder to finish and close the tasks channel
 	wgFindFiles.Wait()
@@ -269,113 +836,362 @@ func CalculateFileHashes(
 
 	// Wait for the collector to finish processing all results
 	wgCollector.Wait()
+	//a resumed update may also keep unhashed files of sizes hashed since
+	if (progress.forgotSizes || resume != nil) && ctx.Err() == nil && !progress.aborted {
+		hashForgottenSizes(ctx, hashMap, opt, openSlots, cancel, &progress)
+	}
+	progress.fileErrors.report(opt.ErrorLog)
+	checkpoint := progress.tracker.checkpoint(absRoots[0])
 
-	// A common error channel could be used if you want to explicitly signal errors from goroutines
-	// and stop the process early if ignoreErrors is false. For now, workers just pass errors
-	// in fileResult, and the collector handles them (or ignores them based on flag).
-	// If any worker encountered a non-ignorable error, it would be returned via fileResult.Err,
-	// but currently the main CalculateFileHashes only returns errors from WalkDir itself.
-	// To truly propagate a worker error to the main function, an error channel would be needed.
-	//
+	// Workers pass errors in fileResult, and the collector reports them. A non-ignorable
+	// error cancels the context: the walk stops, the tasks already queued are hashed by
+	// the workers and the collector drains every buffered result, so the returned map
+	// reflects everything that was actually hashed.
+	if progress.limitReached {
+		return hashMap, &checkpoint, ErrFileLimitReached
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return hashMap, &checkpoint, ErrRuntimeExceeded
+	}
+	if ctx.Err() != nil || progress.aborted {
+		return hashMap, &checkpoint, ErrScanInterrupted
+	}
 
-	return hashMap, nil
+	return hashMap, nil, nil
 }
 
 const TERM_POS int = 100                   //limits the positioning of file status in output
 const SEP_WIDTH int = 70                   //width of  ---  separator
 var indent string = strings.Repeat(" ", 8) // one tabs (8 spaces) from filename column start
 
+// isReclaimable tells if the duplicate path can be removed from dir keeping at
+// least one copy: always when a copy lives in another folder, otherwise all the
+// copies but the first one (by path order) are reclaimable.
+func isReclaimable(path string, dir string, group []string) bool {
+	keeper := path
+	for _, member := range group {
+		if filepath.Dir(member) != dir {
+			return true
+		}
+		if member < keeper {
+			keeper = member
+		}
+	}
+	return keeper != path
+}
+
+// shownHashLen is the number of hash characters shown by --show-hash
+const shownHashLen int = 12
+
+// hashLabel returns the truncated hash appended to file lines by --show-hash,
+// empty when the option is off. Files with a unique size were never hashed.
+func hashLabel(hash string, opt cfg.Options) string {
+	if !opt.ShowHash {
+		return ""
+	}
+	if hash == "" {
+		return " [not hashed]"
+	}
+	if utils.IsPartialHash(hash) {
+		return " [" + hash + "]"
+	}
+	return " [" + hash[:utils.Min(len(hash), shownHashLen)] + "]"
+}
+
+// folderOutput is the rendered listing of one folder together with its statistics.
+type folderOutput struct {
+	text      string
+	stats     counters.Stats
+	histogram counters.SizeHistogram
+}
+
+// lineSpan is the text of a file in the lines of its folder
+type lineSpan struct {
+	start int
+	end   int
+	dup   bool
+}
+
+// limitLines keeps the lines of at most limit files, the duplicates first,
+// in their order, followed by a note with the number of files left out.
+func limitLines(text string, spans []lineSpan, limit int) string {
+	keep := make([]bool, len(spans))
+	numKept := 0
+	for _, dupFirst := range []bool{true, false} {
+		for i, span := range spans {
+			if numKept < limit && span.dup == dupFirst {
+				keep[i] = true
+				numKept++
+			}
+		}
+	}
+	var out strings.Builder
+	for i, span := range spans {
+		if keep[i] {
+			out.WriteString(text[span.start:span.end])
+		}
+	}
+	fmt.Fprintf(&out, "  ... and %d more files\n", len(spans)-numKept)
+	return out.String()
+}
+
+// folderShown tells if a folder passes the --min-dir-perc, --min-dir-bytes,
+// --min-reclaim-perc and --hide-clean filters.
+func folderShown(dirStats counters.Stats, opt cfg.Options) bool {
+	return (!opt.HideClean || dirStats.NumDupFiles > 0) &&
+		opt.MinDirPerc <= utils.Max(int(dirStats.DupPerc()), int(dirStats.DupSizePerc())) &&
+		opt.MinDirBytes <= dirStats.SizeofDupFiles &&
+		float32(opt.MinReclaimPerc) <= dirStats.ReclaimPerc()
+}
+
+// displayPath returns path as shown in the listing: aliased, decoded with
+// --filename-encoding and with control characters escaped.
+func displayPath(path string, opt cfg.Options) string {
+	return utils.EscapeControl(utils.DecodeName(opt.Aliases.Display(path), opt.FilenameEncoding))
+}
+
+// folderHeader renders the folder name and its statistics between separators.
+func folderHeader(dir string, dirStats counters.Stats, palette Palette, opt cfg.Options) string {
+	var out strings.Builder
+	out.WriteString(palette.Header)
+	utils.FprintSeparator(&out, SEP_WIDTH)
+	fmt.Fprintf(&out, "FOLDER: %s\n", displayPath(dir, opt))
+	out.WriteString(dirStats.StringSummary())
+	utils.FprintSeparator(&out, SEP_WIDTH)
+	out.WriteString(palette.HeaderReset)
+	return out.String()
+}
+
+// processSingleFolder classifies the files of a folder and renders its listing.
+// It does not print anything, so that folders can be processed concurrently.
+// When chunked, filesList is a part of a huge folder (--stream-chunk) and only
+// the file lines are rendered, the caller prints the folder header at the end.
 func processSingleFolder(
 	filesList []string,
 	dir string,
+	chunked bool,
 	sizeByFile map[string]int64,
-	overallStats *counters.Stats,
 	hashMap map[utils.HashPair][]string,
 	reverseHashMap map[string]utils.HashPair,
+	tmpl lineTemplate,
+	verifier *matchVerifier,
+	palette Palette,
+	grep *regexp.Regexp, //--grep, nil lists every file
 	opt cfg.Options,
-) {
+) folderOutput {
 	var sb strings.Builder
 	var dirStats counters.Stats
+	var histogram counters.SizeHistogram
+	var numUnindexed int
+
+	// records are rendered as JSON or by the template instead of the colored lines
+	var writeRecord func(rec fileRecord)
+	switch {
+	case opt.JSONAll:
+		writeRecord = func(rec fileRecord) {
+			//JSON strings are UTF-8, invalid names would be silently mangled
+			rec.NonUTF8 = !utf8.ValidString(rec.Path)
+			rec.Path = utils.DecodeName(rec.Path, opt.FilenameEncoding)
+			for i, dupPath := range rec.Duplicates {
+				rec.Duplicates[i] = utils.DecodeName(dupPath, opt.FilenameEncoding)
+			}
+			writeJSONRecord(&sb, rec)
+		}
+	case tmpl != nil && opt.NullTerminated:
+		writeRecord = func(rec fileRecord) { tmpl.render(&sb, rec, 0) }
+	case tmpl != nil:
+		writeRecord = func(rec fileRecord) {
+			rec.Path = utils.EscapeControl(utils.DecodeName(rec.Path, opt.FilenameEncoding))
+			tmpl.render(&sb, rec, '\n')
+		}
+	}
+	if writeRecord != nil && len(opt.Aliases) > 0 {
+		write := writeRecord
+		writeRecord = func(rec fileRecord) {
+			rec.Path = opt.Aliases.Display(rec.Path)
+			rec.Duplicates = opt.Aliases.DisplayAll(rec.Duplicates)
+			write(rec)
+		}
+	}
+
+	//--limit-per-folder: the lines of each file are a span of sb, duplicates
+	//are the files that increment the duplicate counter
+	var spans []lineSpan
+	spanStart, spanDups := 0, dirStats.NumDupFiles
+	endSpan := func() {
+		if sb.Len() > spanStart {
+			spans = append(spans, lineSpan{start: spanStart, end: sb.Len(), dup: dirStats.NumDupFiles > spanDups})
+		}
+		spanStart, spanDups = sb.Len(), dirStats.NumDupFiles
+	}
 
 	filenamespace := utils.Min(utils.MaxFilenameLength(filesList)+8, TERM_POS)
 	sort.Strings(filesList)
 	for _, path := range filesList {
-		filename := filepath.Base(path)
+		endSpan()
+		filename := displayPath(filepath.Base(path), opt)
 
 		filesize := sizeByFile[path]
 
-		oksize := filesize >= opt.MinFileBytes
+		//display filters, the statistics count every file
+		oksize := filesize >= opt.MinFileBytes &&
+			(grep == nil || grep.MatchString(path))
+		//--unindexed-only lists just the files missing from the database
+		indexedShown := oksize && !opt.UnindexedOnly
+		if !oksize {
+			dirStats.AddFilteredFile()
+		}
 
-		if filesize == 0 {
-			utils.FprintfIf(!opt.DuplicatesOnlyFlag && oksize,
-				&sb, "  %-*s", filenamespace, filename)
-			utils.FprintfIf(!opt.DuplicatesOnlyFlag && oksize,
-				&sb, " %sZERO SIZE%s\n", ColorYellow, ColorReset)
-			overallStats.AddIgnoredFile(0)
+		if filesize == 0 && !opt.ZeroByteDuplicates {
+			if writeRecord != nil {
+				if !opt.DuplicatesOnlyFlag && indexedShown {
+					writeRecord(fileRecord{Path: path, Status: StatusZeroSize})
+				}
+			} else {
+				utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
+					&sb, "  %-*s", filenamespace, filename)
+				utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
+					&sb, " %sZERO SIZE%s\n", palette.Warning, palette.Reset)
+			}
 			dirStats.AddIgnoredFile(0)
 			continue
 		}
 
 		hash, exists := reverseHashMap[path]
 		if !exists {
-			utils.FprintfIf(!opt.DuplicatesOnlyFlag && oksize,
-				&sb, "  %-*s", filenamespace, filename)
-			utils.FprintfIf(!opt.DuplicatesOnlyFlag && oksize,
-				&sb, " %sFILE NOT IN DATABASE%s\n", ColorYellow, ColorReset)
-			overallStats.AddIgnoredFile(filesize)
+			if writeRecord != nil {
+				if (!opt.DuplicatesOnlyFlag || opt.UnindexedOnly) && oksize {
+					writeRecord(fileRecord{Path: path, Size: filesize, Status: StatusNotInDB})
+				}
+			} else {
+				utils.FprintfIf((!opt.DuplicatesOnlyFlag || opt.UnindexedOnly) && oksize,
+					&sb, "  %-*s", filenamespace, filename)
+				utils.FprintfIf((!opt.DuplicatesOnlyFlag || opt.UnindexedOnly) && oksize,
+					&sb, " %sFILE NOT IN DATABASE%s\n", palette.Warning, palette.Reset)
+			}
 			dirStats.AddIgnoredFile(filesize)
+			numUnindexed++
 			continue
 		}
 
-		if len(hashMap[hash]) == 1 {
-			overallStats.AddUniqueFile(filesize)
+		group := hashMap[hash]
+		if hash.Hash == "" && len(group) > 1 {
+			//unique size files are not hashed, an empty hash shared by more
+			//files (e.g. databases merged across updates) says nothing of the content
+			if writeRecord != nil {
+				if !opt.DuplicatesOnlyFlag && indexedShown {
+					writeRecord(fileRecord{Path: path, Size: filesize, Status: StatusUnknown})
+				}
+			} else {
+				utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
+					&sb, "  %-*s", filenamespace, filename)
+				utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
+					&sb, " %sNOT HASHED, size shared (run an update)%s\n", palette.Warning, palette.Reset)
+			}
+			dirStats.AddIgnoredFile(filesize)
+			continue
+		}
+		if utils.IsPartialHash(hash.Hash) {
+			//--resume-hash, only the readable prefix was hashed: the content is unknown
+			if writeRecord != nil {
+				if !opt.DuplicatesOnlyFlag && indexedShown {
+					writeRecord(fileRecord{Path: path, Size: filesize, Hash: hash.Hash, Status: StatusUnknown})
+				}
+			} else {
+				utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
+					&sb, "  %-*s", filenamespace, filename)
+				utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
+					&sb, " %sREAD ERROR, partially hashed%s%s\n", palette.Warning, hashLabel(hash.Hash, opt), palette.Reset)
+			}
+			dirStats.AddIgnoredFile(filesize)
+			continue
+		}
+		if verifier != nil && len(group) > 1 && (opt.VerifyOnMatch || len(group) > opt.VerifyGroupsOver) {
+			//quick hash matches are confirmed with the full hash of each member
+			group = verifier.confirmedDuplicates(path, hash, group)
+		}
+
+		if len(group) == 1 {
 			dirStats.AddUniqueFile(filesize)
-			utils.FprintfIf(!opt.DuplicatesOnlyFlag && oksize,
+			if writeRecord != nil {
+				if !opt.DuplicatesOnlyFlag && indexedShown {
+					writeRecord(fileRecord{Path: path, Size: filesize, Hash: hash.Hash, Status: StatusNotDuplicate})
+				}
+				continue
+			}
+			utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
 				&sb, "  %-*s", filenamespace, filename)
-			utils.FprintfIf(!opt.DuplicatesOnlyFlag && oksize,
-				&sb, " %sNOT DUPLICATE (%s)%s\n",
-				ColorGreen,
+			utils.FprintfIf(!opt.DuplicatesOnlyFlag && indexedShown,
+				&sb, " %s%sNOT DUPLICATE (%s)%s%s\n",
+				palette.Unique,
+				palette.UniqueMark,
 				utils.RepresentBytes(filesize),
-				ColorReset)
+				hashLabel(hash.Hash, opt),
+				palette.Reset)
 
 		} else {
-			overallStats.AddDupFile(filesize)
 			dirStats.AddDupFile(filesize)
+			histogram.Add(filesize)
+			if isReclaimable(path, dir, group) {
+				dirStats.AddReclaimable(filesize)
+			}
+			if writeRecord != nil {
+				if indexedShown {
+					writeRecord(fileRecord{Path: path, Size: filesize, Hash: hash.Hash, Status: StatusDuplicate,
+						Duplicates: otherPaths(path, group)})
+				}
+				continue
+			}
 
-			utils.FprintfIf(oksize, &sb, "  %-*s", filenamespace, filename)
-			utils.FprintfIf(oksize, &sb, " %sDUPLICATE OF: (%s)%s\n",
-				ColorLightRed,
+			utils.FprintfIf(indexedShown, &sb, "  %-*s", filenamespace, filename)
+			utils.FprintfIf(indexedShown, &sb, " %s%sDUPLICATE OF: (%s)%s%s\n",
+				palette.Duplicate,
+				palette.DuplicateMark,
 				utils.RepresentBytes(filesize),
-				ColorReset)
-			for _, dupPath := range hashMap[hash] {
+				hashLabel(hash.Hash, opt),
+				palette.Reset)
+			for _, dupPath := range group {
 				if dupPath != path {
-					utils.FprintfIf(oksize,
-						&sb, "%s- %s%s%s\n", indent, ColorCyan, dupPath, ColorReset)
+					utils.FprintfIf(indexedShown,
+						&sb, "%s- %s%s%s\n", indent, palette.DupPath, displayPath(dupPath, opt), palette.Reset)
 				}
 			}
 
 		}
 	}
+	endSpan()
+	if opt.LimitPerFolder > 0 && writeRecord == nil && len(spans) > opt.LimitPerFolder {
+		limited := limitLines(sb.String(), spans, opt.LimitPerFolder)
+		sb.Reset()
+		sb.WriteString(limited)
+	}
+
+	if chunked {
+		//the folder header follows its last chunk, folder filters cannot apply to the lines
+		if opt.OutputType == 0 {
+			return folderOutput{text: sb.String(), stats: dirStats, histogram: histogram}
+		}
+		return folderOutput{stats: dirStats, histogram: histogram}
+	}
 
-	if opt.MinDirPerc <= utils.Max(int(dirStats.DupPerc()), int(dirStats.DupSizePerc())) &&
-		opt.MinDirBytes <= dirStats.SizeofDupFiles {
-		//Output Directory header
-		if opt.OutputType <= 1 {
-			fmt.Print(ColorLightBlue)
-			utils.PrintSeparator(SEP_WIDTH)
-			fmt.Printf("FOLDER: %s\n", dir)
-			fmt.Print(dirStats.StringSummary())
-			utils.PrintSeparator(SEP_WIDTH)
-			fmt.Print(ColorReset)
+	var out strings.Builder
+	if folderShown(dirStats, opt) && (!opt.UnindexedOnly || numUnindexed > 0) {
+		//Output Directory header, template and JSON outputs have only the file lines
+		if opt.OutputType <= 1 && writeRecord == nil {
+			out.WriteString(folderHeader(dir, dirStats, palette, opt))
 		}
 		//Output Files info for this Directory
-		if opt.OutputType == 0 {
-			fmt.Println(sb.String())
+		if opt.OutputType == 0 && writeRecord != nil {
+			out.WriteString(sb.String())
+		} else if opt.OutputType == 0 {
+			out.WriteString(sb.String())
+			out.WriteString("\n")
 		}
-		if opt.OutputType <= 1 {
-			fmt.Println()
+		if opt.OutputType <= 1 && writeRecord == nil {
+			out.WriteString("\n")
 		}
 	}
+	return folderOutput{text: out.String(), stats: dirStats, histogram: histogram}
 }
 
 func ListFiles(
@@ -385,18 +1201,130 @@ func ListFiles(
 	reverseHashMap map[string]utils.HashPair,
 ) error {
 
+	if opt.NullTerminated && opt.Format == "" {
+		opt.Format = "{path}" //like find -print0
+	}
+	var tmpl lineTemplate
+	if opt.Format != "" {
+		var err error
+		if tmpl, err = parseLineTemplate(opt.Format); err != nil {
+			return err
+		}
+	}
+
+	palette, err := PaletteFor(opt.ColorScheme, opt.ColorSections)
+	if err != nil {
+		return err
+	}
+	var grep *regexp.Regexp
+	if opt.Grep != "" {
+		if grep, err = regexp.Compile(opt.Grep); err != nil {
+			return fmt.Errorf("invalid --grep: %w", err)
+		}
+	}
+	if err := utils.CheckEncoding(opt.FilenameEncoding); err != nil {
+		return err
+	}
+
+	if opt.LimitPerFolder < 0 {
+		return fmt.Errorf("limit per folder must be 0 (unlimited) or greater")
+	}
+	if opt.LimitPerFolder > 0 && opt.StreamChunk > 0 {
+		return fmt.Errorf("--limit-per-folder and --stream-chunk cannot be used together")
+	}
+
+	var verifier *matchVerifier
+	if opt.VerifyGroupsOver < 0 {
+		return fmt.Errorf("rehash on collision threshold must be 0 (disabled) or greater")
+	}
+	if opt.VerifyOnMatch || opt.VerifyGroupsOver > 0 {
+		verifier = newMatchVerifier()
+	}
+
 	var overallStats counters.Stats
+	var skippedStats counters.Stats                 //entries skipped by the walk, merged once the pool is done
+	rootStats := make([]counters.Stats, len(paths)) //--summary-by-root
+	var rootIdx int                                 //path being walked
+	var hardlinkStats counters.HardlinkStats
+	var histogram counters.SizeHistogram
 	var filesInDir []string
+	var chunked bool                //the current folder is streamed in chunks
+	var chunkedStats counters.Stats //stats of the chunks emitted for the streamed folder
 	var currPath string
 	//filesByDir := make(map[string][]string)
 	sizeByFile := make(map[string]int64)
 
-	for _, pathname := range paths {
+	//folders are classified by the pool, outputs are printed in walk order
+	pool := newFolderPool(opt.ParallelFolders,
+		func(job *folderJob) folderOutput {
+			return processSingleFolder(
+				job.files,
+				job.dir,
+				job.chunked,
+				job.sizeByFile,
+				hashMap,
+				reverseHashMap,
+				tmpl,
+				verifier,
+				palette,
+				grep,
+				opt,
+			)
+		},
+		func(job *folderJob, out folderOutput) {
+			fmt.Print(out.text)
+			overallStats.Merge(out.stats)
+			rootStats[job.root].Merge(out.stats)
+			histogram.Merge(out.histogram)
+			if !job.chunked {
+				return
+			}
+			chunkedStats.Merge(out.stats)
+			if job.lastChunk {
+				if opt.OutputType <= 1 && tmpl == nil && !opt.JSONAll && folderShown(chunkedStats, opt) {
+					fmt.Print(folderHeader(job.dir, chunkedStats, palette, opt))
+					fmt.Println()
+				}
+				chunkedStats = counters.Stats{}
+			}
+		})
+	//flushFolder hands the files collected for the current folder to the pool
+	flushFolder := func() {
+		if len(filesInDir) > 0 || chunked {
+			pool.submit(&folderJob{files: filesInDir, dir: currPath, sizeByFile: sizeByFile,
+				chunked: chunked, lastChunk: chunked, root: rootIdx})
+		}
+		filesInDir = nil
+		sizeByFile = make(map[string]int64)
+		chunked = false
+	}
+	//flushChunk hands a part of a huge folder to the pool, bounding the memory
+	flushChunk := func() {
+		pool.submit(&folderJob{files: filesInDir, dir: currPath, sizeByFile: sizeByFile, chunked: true, root: rootIdx})
+		filesInDir = nil
+		sizeByFile = make(map[string]int64)
+		chunked = true
+	}
+
+	dirs := utils.NewDirTracker()
+	for i, pathname := range paths {
+		rootIdx = i
 		currPath = ""
 		err := utils.HybridWalk(pathname, func(path string, d os.DirEntry, err error) error {
+			if skip, skipErr := excluded(path, pathname, d, opt); skip {
+				return skipErr
+			}
+			//without recursion only the roots are walked, their subfolders are not visits
+			if err == nil && d != nil && d.IsDir() && (opt.RecurseFlag || path == pathname) && !dirs.FirstVisit(path, d) {
+				slog.Info("skipping folder already walked from another path", "path", path)
+				return filepath.SkipDir
+			}
 			absPath, size, err := utils.CheckFile(path, d, err, opt.RecurseFlag, pathname)
+			if errors.Is(err, utils.ErrNotRegular) {
+				skippedStats.AddSkippedNonRegular()
+			}
 			if err != nil && err != filepath.SkipDir {
-				fmt.Fprintf(os.Stderr, "Error while accessing file %s details: %v\n", path, err)
+				slog.Warn("failed to access file", "path", path, "err", err)
 				if opt.IgnoreErrorsFlag {
 					err = nil
 				}
@@ -405,6 +1333,11 @@ func ListFiles(
 			if err != nil {
 				return err
 			}
+			if linkPath, hashPair, ok := symlinkEntry(path, d, opt); ok {
+				absPath, size = linkPath, hashPair.Filesize
+			} else if d.Type()&fs.ModeSymlink != 0 {
+				skippedStats.AddSkippedSymlink()
+			}
 			if absPath == "" {
 				return nil
 			}
@@ -417,52 +1350,68 @@ func ListFiles(
 
 			if currPath != dir {
 				//we are in another folder let's process previous one
-
-				processSingleFolder(
-					filesInDir,
-					currPath,
-					sizeByFile,
-					&overallStats,
-					hashMap,
-					reverseHashMap,
-					opt,
-				)
-
-				filesInDir = nil
-				sizeByFile = make(map[string]int64)
+				flushFolder()
 				currPath = dir
 			}
 
 			filesInDir = append(filesInDir, absPath)
 			sizeByFile[absPath] = size
+			if opt.StreamChunk > 0 && len(filesInDir) >= opt.StreamChunk {
+				flushChunk()
+			}
+
+			if opt.HardlinkSavings {
+				if info, infoErr := d.Info(); infoErr == nil {
+					if id, nlink, ok := utils.FileIdentity(info); ok && nlink > 1 {
+						hardlinkStats.AddLinkedFile(id, size)
+					}
+				}
+			}
 
 			return nil
 		})
 		if err != nil {
-			errwalk := fmt.Errorf("failed to walk directory or access file %s: %w", pathname, err)
-			fmt.Fprintf(os.Stderr, errwalk.Error())
+			slog.Error("failed to walk directory or access file", "path", pathname, "err", err)
 			if !opt.IgnoreErrorsFlag {
+				pool.close()
 				return err
 			}
 		}
 
 		//The last folder ends without triggering the process, here we have to do:
-		processSingleFolder(
-			filesInDir,
-			currPath,
-			sizeByFile,
-			&overallStats,
-			hashMap,
-			reverseHashMap,
-			opt,
-		)
+		flushFolder()
+
+	}
+	pool.close() //waits for all the folders to be printed
+	overallStats.Merge(skippedStats)
 
+	if opt.JSONAll || opt.NullTerminated {
+		return nil //machine output, only the file records
 	}
 
+	if opt.SummaryByRoot && len(paths) > 1 {
+		utils.PrintSeparator(SEP_WIDTH)
+		fmt.Println("STATS BY ROOT")
+		fmt.Print(rootStatsTable(paths, rootStats, opt))
+	}
 	//Write overall stats
 	utils.PrintSeparator(SEP_WIDTH)
 	fmt.Println("OVERALL STATS")
 	fmt.Print(overallStats.StringSummary())
+	fmt.Print(overallStats.SkippedSummary())
+	if verifier != nil {
+		fmt.Print(verifier.summary())
+	}
 	utils.PrintSeparator(SEP_WIDTH)
+	if opt.HardlinkSavings {
+		fmt.Println("HARDLINK SAVINGS")
+		fmt.Print(hardlinkStats.StringSummary(overallStats.SizeofFiles))
+		utils.PrintSeparator(SEP_WIDTH)
+	}
+	if opt.SizeHistogram {
+		fmt.Println("DUPLICATES BY FILE SIZE")
+		fmt.Print(histogram.StringTable())
+		utils.PrintSeparator(SEP_WIDTH)
+	}
 	return nil
 }